@@ -14,6 +14,7 @@ var (
 	c           = flag.String("c", "midiconverser.yml", "config file name (YAML)")
 	i           = flag.String("i", "", "input file name (YAML)")
 	addChecksum = flag.Bool("add_checksum", false, "automatically add checksum to the input YAML")
+	addTracks   = flag.Bool("add_tracks", false, "automatically add melody and bass tracks assigned by register to the input YAML (turns on auto_assign_tracks)")
 	oPrefix     = flag.String("o_prefix", "", "output file name for outputting separate files")
 )
 
@@ -31,14 +32,21 @@ func Main() error {
 
 	options, err := file.ReadOptions(fsys, *i)
 	if err != nil {
-		return fmt.Errorf("failed to read options: %v", err)
+		return fmt.Errorf("failed to read options %v: %v", *i, err)
 	}
 
 	wantChecksum := options.InputFileSHA256 == ""
+	wantTracks := *addTracks && options.MelodyTracks == nil && options.BassTracks == nil
+	if wantTracks {
+		if options.AutoAssignTracks != nil && !*options.AutoAssignTracks {
+			return fmt.Errorf("-add_tracks requires auto_assign_tracks, which is turned off in %v", *i)
+		}
+		config.AutoAssignTracks = true
+	}
 
-	output, err := file.Process(fsys, config, options)
+	output, assigned, err := file.Process(fsys, config, options)
 	if err != nil {
-		return fmt.Errorf("failed to process: %v", err)
+		return fmt.Errorf("failed to process %v: %v", *i, err)
 	}
 
 	if *oPrefix == "" {
@@ -53,7 +61,11 @@ func Main() error {
 		}
 	}
 
-	if wantChecksum && options.InputFileSHA256 != "" {
+	gotTracks := assigned.MelodyTracks != nil || assigned.BassTracks != nil
+	if wantTracks && gotTracks {
+		options.MelodyTracks, options.BassTracks = assigned.MelodyTracks, assigned.BassTracks
+	}
+	if (wantChecksum && options.InputFileSHA256 != "") || (wantTracks && gotTracks) {
 		err := file.WriteOptions(*i, options)
 		if err != nil {
 			return fmt.Errorf("failed to write %v: %v", *i, err)
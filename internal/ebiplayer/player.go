@@ -1721,7 +1721,11 @@ func (p *UI) updateWidgets() {
 		if p.uiState.UnrolledNumVerses != 0 {
 			postludeSuffix += fmt.Sprintf("=%d", p.uiState.UnrolledNumVerses)
 		}
-		p.verseLabel.Label = fmt.Sprintf("Verse: %d/%d%s", p.uiState.Verse+1, p.uiState.NumVerses, postludeSuffix)
+		sectionSuffix := ""
+		if p.uiState.Section != "" {
+			sectionSuffix = fmt.Sprintf(" (%s)", p.uiState.Section)
+		}
+		p.verseLabel.Label = fmt.Sprintf("Verse: %d/%d%s%s", p.uiState.Verse+1, p.uiState.NumVerses, postludeSuffix, sectionSuffix)
 		p.verseLabel.GetWidget().Visibility = widget.Visibility_Show
 		p.fewerVerses.GetWidget().Visibility = widget.Visibility_Show
 		p.moreVerses.GetWidget().Visibility = widget.Visibility_Show
@@ -20,5 +20,40 @@ func ReadConfig(fsys fs.FS, configFile string) (*processor.Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not decode: %v", err)
 	}
+	err = loadSysEx(fsys, &config)
+	if err != nil {
+		return nil, fmt.Errorf("could not load SysEx: %v", err)
+	}
 	return &config, nil
 }
+
+// loadSysEx reads and validates all SysEx messages the config refers to.
+func loadSysEx(fsys fs.FS, config *processor.Config) error {
+	for i := range config.SysEx {
+		init := &config.SysEx[i]
+		_, err := init.MatchesPort("")
+		if err != nil {
+			return err
+		}
+		init.Messages = nil
+		for _, name := range init.Files {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return fmt.Errorf("could not read %v: %v", name, err)
+			}
+			msgs, err := processor.ParseSysEx(data)
+			if err != nil {
+				return fmt.Errorf("could not parse %v: %v", name, err)
+			}
+			init.Messages = append(init.Messages, msgs...)
+		}
+		for _, h := range init.Hex {
+			msgs, err := processor.ParseSysExHex(h)
+			if err != nil {
+				return err
+			}
+			init.Messages = append(init.Messages, msgs...)
+		}
+	}
+	return nil
+}
@@ -12,28 +12,51 @@ import (
 )
 
 // Process processes the given options file. May mutate options - if so, main program may want to write it back.
-func Process(fsys fs.FS, config *processor.Config, options *processor.Options) (map[processor.OutputKey]*smf.SMF, error) {
+// Also returns the tracks assigned by register, which the main program may want to write back as well.
+func Process(fsys fs.FS, config *processor.Config, options *processor.Options) (map[processor.OutputKey]*smf.SMF, *processor.TrackAssignment, error) {
 	inBytes, err := fs.ReadFile(fsys, options.InputFile)
 	if err != nil {
-		return nil, fmt.Errorf("could not read %v: %v", options.InputFile, err)
+		return nil, nil, fmt.Errorf("could not read %v: %v", options.InputFile, err)
 	}
 
 	sum := fmt.Sprintf("%x", sha256.Sum256(inBytes))
 
 	if options.InputFileSHA256 != "" && options.InputFileSHA256 != sum {
-		return nil, fmt.Errorf("mismatching checksum of %v: got %v, want %v", options.InputFile, sum, options.InputFileSHA256)
+		return nil, nil, fmt.Errorf("mismatching checksum of %v: got %v, want %v", options.InputFile, sum, options.InputFileSHA256)
 	}
 	options.InputFileSHA256 = sum
 
-	in, err := smf.ReadFrom(bytes.NewReader(inBytes))
+	in, err := readSMF(inBytes)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse %v: %v", options.InputFile, err)
+		return nil, nil, fmt.Errorf("could not parse %v: %v", options.InputFile, err)
 	}
 
-	output, err := processor.Process(in, config, options)
+	output, assigned, err := processor.Process(in, config, options)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process %v: %v", options.InputFile, err)
+		return nil, nil, fmt.Errorf("failed to process %v: %v", options.InputFile, err)
 	}
 
-	return output, nil
+	return output, assigned, nil
+}
+
+// readSMF parses a MIDI file.
+//
+// The MIDI library cannot read files using SMPTE time, so these are read as if they used metric ticks, and the time format is restored afterwards.
+func readSMF(inBytes []byte) (*smf.SMF, error) {
+	if len(inBytes) < 14 || string(inBytes[:4]) != "MThd" || inBytes[12]&0x80 == 0 {
+		return smf.ReadFrom(bytes.NewReader(inBytes))
+	}
+	// The division field holds the negative frame rate and the subframes.
+	timeCode := smf.TimeCode{
+		FramesPerSecond: uint8(-int8(inBytes[12])),
+		SubFrames:       inBytes[13],
+	}
+	patched := bytes.Clone(inBytes)
+	patched[12], patched[13] = 0, 96
+	in, err := smf.ReadFrom(bytes.NewReader(patched))
+	if err != nil {
+		return nil, err
+	}
+	in.TimeFormat = timeCode
+	return in, nil
 }
@@ -151,6 +151,9 @@ type UIState struct {
 	// Verse is the current verse.
 	Verse int
 
+	// Section is the current section of the song form, such as Refrain. Empty if the hymn has no song form.
+	Section string
+
 	// Comment is the hymn comment string.
 	Comment string
 
@@ -342,6 +345,27 @@ func (b *Backend) updateOutPort() error {
 		b.outPort.Close()
 	}
 	b.outPort = port
+	return b.sendSysExInit()
+}
+
+// sendSysExInit sends the configured initialization messages to the current output port.
+func (b *Backend) sendSysExInit() error {
+	for _, init := range b.config.SysEx {
+		match, err := init.MatchesPort(b.outPort.String())
+		if err != nil {
+			return err
+		}
+		if !match {
+			continue
+		}
+		log.Printf("Sending %d SysEx messages to %v.", len(init.Messages), b.outPort)
+		for _, msg := range init.Messages {
+			err := b.outPort.Send(msg)
+			if err != nil {
+				return fmt.Errorf("could not send SysEx to %v: %w", b.outPort, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -457,7 +481,7 @@ func fixOutput(output map[processor.OutputKey]*smf.SMF) error {
 
 // process processes the given input.
 func (b *Backend) process(options *processor.Options) (map[processor.OutputKey]*smf.SMF, error) {
-	output, err := file.Process(b.fsys, &b.config, options)
+	output, _, err := file.Process(b.fsys, &b.config, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process: %w", err)
 	}
@@ -628,6 +652,10 @@ func (b *Backend) singlePlayer(optionsFile string) error {
 	if err != nil {
 		return fmt.Errorf("failed to process %v: %w", optionsFile, err)
 	}
+	verses, err := processor.FormVerses(options)
+	if err != nil {
+		return fmt.Errorf("failed to process %v: %w", optionsFile, err)
+	}
 
 	key := processor.OutputKey{Special: processor.Panic}
 	allOff := output[key]
@@ -637,7 +665,9 @@ func (b *Backend) singlePlayer(optionsFile string) error {
 
 	b.uiState.PlayOne = optionsFile
 	b.uiState.CurrentFile = optionsFile
-	b.uiState.NumVerses = processor.WithDefault(options.NumVerses, 1)
+	hymnNumVerses := processor.WithDefault(options.NumVerses, len(verses))
+	perVerse := processor.PerVerseParts(options)
+	b.uiState.NumVerses = hymnNumVerses
 	b.uiState.UnrolledNumVerses = options.UnrolledNumVerses
 	b.uiState.Comment = options.Comment
 	b.uiState.HavePostlude = output[processor.OutputKey{Special: processor.Postlude}] != nil
@@ -651,6 +681,7 @@ func (b *Backend) singlePlayer(optionsFile string) error {
 		b.uiState.Comment = ""
 		b.uiState.HavePostlude = false
 		b.uiState.Verse = 0
+		b.uiState.Section = ""
 		b.uiState.CurrentMessage = "" // Written to by prompt.
 		b.sendUIState()
 	}()
@@ -672,46 +703,70 @@ func (b *Backend) singlePlayer(optionsFile string) error {
 
 	for i := 0; i < b.uiState.NumVerses; i++ {
 		b.uiState.Verse = i
-		n := 0
-		for j := 0; ; j++ {
-			key := processor.OutputKey{Part: j}
-			part := output[key]
-			if part == nil {
-				break
-			}
-			n++
-		}
-		for j := 0; j < n; j++ {
-			key := processor.OutputKey{Part: j}
-			part := output[key]
-			if part == nil {
-				break
-			}
-			var msg string
-			if j == 0 {
-				msg = "Start Verse"
-			} else if j%2 == 1 {
-				msg = "End Fermata"
-			} else {
-				msg = "Continue"
-			}
-			var skipText string
-			if j == 0 {
-				skipText = "Skip Verse"
+		// The verse of the hymn to take the parts from.
+		v := processor.PartsVerse(i, b.uiState.NumVerses, hymnNumVerses)
+		ending := processor.VerseEnding(options, v, hymnNumVerses)
+		for _, section := range processor.VerseSections(verses, v, hymnNumVerses) {
+			title := processor.SectionTitle(section)
+			partKey := func(j int) processor.OutputKey {
+				key := processor.OutputKey{Part: j, Section: section}
+				if section == "" {
+					key.Ending = ending
+				}
+				if perVerse {
+					key.Verse = v + 1
+				}
+				return key
 			}
-			skip, err := b.prompt(msg, fmt.Sprintf("playing part %d/%d", j+1, n), skipText)
-			if err != nil {
-				return err
+			if len(options.Form) > 0 {
+				b.uiState.Section = title
 			}
-			if skip {
-				break
+			n := 0
+			for j := 0; ; j++ {
+				key := partKey(j)
+				part := output[key]
+				if part == nil {
+					break
+				}
+				n++
 			}
-			err = b.playMIDI(part, key)
-			if err != nil {
-				return fmt.Errorf("could not play %v part %v: %w", optionsFile, j, err)
+			for j := 0; j < n; j++ {
+				key := partKey(j)
+				part := output[key]
+				if part == nil {
+					break
+				}
+				var msg string
+				if j == 0 {
+					msg = "Start " + title
+				} else if j%2 == 1 {
+					msg = "End Fermata"
+				} else {
+					msg = "Continue"
+				}
+				var skipText string
+				if j == 0 {
+					skipText = "Skip " + title
+				}
+				response := fmt.Sprintf("playing part %d/%d", j+1, n)
+				if section != "" {
+					response = fmt.Sprintf("playing %s part %d/%d", section, j+1, n)
+				}
+				skip, err := b.prompt(msg, response, skipText)
+				if err != nil {
+					return err
+				}
+				if skip {
+					break
+				}
+				err = b.playMIDI(part, key)
+				if err != nil {
+					return fmt.Errorf("could not play %v %v: %w", optionsFile, key, err)
+				}
 			}
 		}
 	}
+	b.uiState.Section = ""
 
 	key = processor.OutputKey{Special: processor.Postlude}
 	postlude := output[key]
@@ -2,8 +2,6 @@ package processor
 
 import (
 	"fmt"
-
-	"gitlab.com/gomidi/midi/v2/smf"
 )
 
 func abs(x int64) int64 {
@@ -13,29 +11,35 @@ func abs(x int64) int64 {
 	return x
 }
 
-func adjustToNoNotes(mid *smf.SMF, tick, maxDelta int64) (int64, error) {
+func adjustToNoNotes(tl *timeline, tick, maxDelta int64) (int64, error) {
 	// Look for a tick with zero notes playing at start.
-	tracker := NewNoteTracker(false)
+	// Ticks further away than maxDelta are of no use, so start right before them.
+	start := tl.seek(tick - maxDelta)
+	tracker := tl.notesBefore(start)
 	var bestTick, maxTick int64
-	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
-		if !tracker.Playing() {
-			if time > maxTick {
-				//log.Printf("Nothing at %v .. %v.", maxTick+1, time)
-				if tick >= maxTick+1 && tick <= time {
-					bestTick = tick
-				}
-				if abs(maxTick+1-tick) < abs(bestTick-tick) {
-					bestTick = maxTick + 1
-				}
-			} else {
-				//log.Printf("Nothing at %v.", time)
+	if start > 0 {
+		maxTick, _ = tl.tick(start - 1)
+	}
+	err := tl.forEachTick(start, func(time int64, events []timedEvent) error {
+		if !tracker.Playing() && time > maxTick {
+			//log.Printf("Nothing at %v .. %v.", maxTick+1, time)
+			if tick >= maxTick+1 && tick <= time {
+				bestTick = tick
+			}
+			if abs(maxTick+1-tick) < abs(bestTick-tick) {
+				bestTick = maxTick + 1
 			}
+		}
+		// Notes ending at this tick do not prevent cutting here.
+		tracker.handleNoteEnds(time, events)
+		if !tracker.Playing() {
+			//log.Printf("Nothing at %v.", time)
 			if abs(time-tick) < abs(bestTick-tick) {
 				bestTick = time
 			}
 		}
+		tracker.handleNoteStarts(time, events)
 		maxTick = time
-		tracker.Handle(time, track, msg)
 		if time > tick+maxDelta {
 			return StopIteration
 		}
@@ -55,39 +59,17 @@ func adjustToNoNotes(mid *smf.SMF, tick, maxDelta int64) (int64, error) {
 	return bestTick, nil
 }
 
-func adjustFermata(mid *smf.SMF, tf *tickFermata) error {
+func adjustFermata(tl *timeline, tf *tickFermata) error {
 	fermataNotes := map[Key]struct{}{}
 	first := true
 	var firstTick int64
 	haveHoldTick := false
 	waitingForNote := false
 	finished := false
-	tracker := NewNoteTracker(false)
+	start := tl.seek(tf.tick)
+	tracker := tl.notesBefore(start)
 	//log.Printf("Fermata %v.", tf)
-	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
-		if time < tf.tick {
-			tracker.Handle(time, track, msg)
-			return nil
-		}
-
-		// The start tick shall use the UNION of notes played and released.
-		if first || time == firstTick {
-			first = false
-			firstTick = time
-			for _, k := range tracker.NotesPlaying() {
-				//log.Printf("[%d] Add note %v.", time, k)
-				fermataNotes[k] = struct{}{}
-			}
-		}
-		//log.Printf("[%d] Event: %v.", time, msg)
-		tracker.Handle(time, track, msg)
-		if time == firstTick {
-			for _, k := range tracker.NotesPlaying() {
-				//log.Printf("[%d] Add note %v.", time, k)
-				fermataNotes[k] = struct{}{}
-			}
-		}
-
+	check := func(time int64) bool {
 		//log.Printf("[%d] Tracker playing: %v.", time, tracker.Playing())
 		anyMissing := false
 		allMissing := true
@@ -112,6 +94,35 @@ func adjustFermata(mid *smf.SMF, tf *tickFermata) error {
 			//log.Printf("[%d] Release tick received, finished.", time)
 			tf.releaseTick = time
 			finished = true
+		}
+		return finished
+	}
+	// Each tick is handled as if its note off events came first, regardless of event order.
+	err := tl.forEachTick(start, func(time int64, events []timedEvent) error {
+		// The start tick shall use the UNION of notes played and released.
+		if first {
+			first = false
+			firstTick = time
+			for _, k := range tracker.NotesPlaying() {
+				//log.Printf("[%d] Add note %v.", time, k)
+				fermataNotes[k] = struct{}{}
+			}
+		}
+		// If the fermata is on a tick with events, notes started there are part of the fermata too.
+		// So only check once they are, or an empty set of notes after a rest releases the fermata right away.
+		atFermata := time == firstTick && time == tf.tick
+		tracker.handleNoteEnds(time, events)
+		if !atFermata && check(time) {
+			return StopIteration
+		}
+		tracker.handleNoteStarts(time, events)
+		if atFermata {
+			for _, k := range tracker.NotesPlaying() {
+				//log.Printf("[%d] Add note %v.", time, k)
+				fermataNotes[k] = struct{}{}
+			}
+		}
+		if check(time) {
 			return StopIteration
 		}
 		return nil
@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// articulate shortens notes that are followed by the same note, so that the release is audible.
+//
+// The gap is given in beats or in milliseconds. Notes ending during a fermata are left alone, as the fermata rest already releases them.
+func articulate(tl *timeline, b bars, beats, ms float64, fermataTick []tickFermata) error {
+	if beats <= 0 && ms <= 0 {
+		return nil
+	}
+	inFermata := func(tick int64) bool {
+		for _, tf := range fermataTick {
+			if tick > tf.holdTick && (tf.releaseTick < 0 || tick <= tf.releaseTick) {
+				return true
+			}
+		}
+		return false
+	}
+	qpm := 120.0
+	gap := func(tick int64) int64 {
+		if beats > 0 {
+			i, _ := b.FromTick(tick)
+			return int64(beats * float64(b[i].BeatLength()))
+		}
+		return int64(tl.timeFormat.(smf.MetricTicks).Ticks(qpm, time.Duration(ms*float64(time.Millisecond))))
+	}
+
+	events := tl.events
+	noteOn := map[Key]int64{}
+	noteOff := map[Key]int{}
+	shortened := 0
+	for i, ev := range events {
+		ev.msg.GetMetaTempo(&qpm)
+		var ch, note uint8
+		if ev.msg.GetNoteStart(&ch, &note, nil) {
+			k := Key{ch: ch, note: note}
+			if j, found := noteOff[k]; found && !inFermata(events[j].time) {
+				start := noteOn[k]
+				off := events[j].time
+				newOff := max(ev.time-gap(ev.time), start+(off-start)/2)
+				if newOff < off {
+					events[j].time = newOff
+					shortened++
+				}
+			}
+			delete(noteOff, k)
+			noteOn[k] = ev.time
+		}
+		if ev.msg.GetNoteEnd(&ch, &note) {
+			noteOff[Key{ch: ch, note: note}] = i
+		}
+	}
+	if shortened == 0 {
+		return nil
+	}
+
+	// Moved events are now out of order.
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].time < events[j].time
+	})
+	tl.index()
+	log.Printf("Shortened %d notes for articulation.", shortened)
+	return nil
+}
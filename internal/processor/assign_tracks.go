@@ -0,0 +1,207 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// trackRegister are the pitch statistics of a track.
+type trackRegister struct {
+	notes    int
+	sum      int
+	min, max uint8
+
+	// Numbers of note starts at which the track plays the highest or lowest sounding note.
+	highest, lowest int
+}
+
+func (r *trackRegister) mean() float64 {
+	return float64(r.sum) / float64(r.notes)
+}
+
+// analyzeRegisters computes the pitch statistics of all tracks, ignoring percussion.
+func analyzeRegisters(mid *smf.SMF, percussion map[routeKey]string) ([]trackRegister, error) {
+	type soundingKey struct {
+		track int
+		ch    uint8
+		note  uint8
+	}
+
+	registers := make([]trackRegister, len(mid.Tracks))
+	sounding := map[soundingKey]int{}
+	var started bool
+	lastTime := int64(-1)
+	// flush compares the notes sounding after all events of a tick.
+	flush := func() {
+		if started && len(sounding) > 0 {
+			var high, low soundingKey
+			first := true
+			for k := range sounding {
+				if first || k.note > high.note || (k.note == high.note && k.track < high.track) {
+					high = k
+				}
+				if first || k.note < low.note || (k.note == low.note && k.track < low.track) {
+					low = k
+				}
+				first = false
+			}
+			registers[high.track].highest++
+			registers[low.track].lowest++
+		}
+		started = false
+	}
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		if time != lastTime {
+			flush()
+			lastTime = time
+		}
+		var ch, note uint8
+		if msg.GetNoteEnd(&ch, &note) {
+			k := soundingKey{track: track, ch: ch, note: note}
+			sounding[k]--
+			if sounding[k] <= 0 {
+				delete(sounding, k)
+			}
+		} else if msg.GetNoteStart(&ch, &note, nil) {
+			if _, found := percussion[routeKey{track: track, ch: ch}]; found {
+				return nil
+			}
+			sounding[soundingKey{track: track, ch: ch, note: note}]++
+			r := &registers[track]
+			if r.notes == 0 || note < r.min {
+				r.min = note
+			}
+			if r.notes == 0 || note > r.max {
+				r.max = note
+			}
+			r.notes++
+			r.sum += int(note)
+			started = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	flush()
+	return registers, nil
+}
+
+// assignTracksByRegister picks the melody and bass tracks from the pitch statistics of the tracks.
+//
+// The melody track is the one most often playing the highest note, the bass track the one most often playing the lowest note.
+// Returns nil for a role that cannot be assigned, such as when only one track plays notes.
+func assignTracksByRegister(registers []trackRegister) (melody, bass []int) {
+	best := func(score func(r *trackRegister) int, better func(a, b *trackRegister) bool, exclude int) int {
+		result := -1
+		for i := range registers {
+			r := &registers[i]
+			if r.notes == 0 || i == exclude {
+				continue
+			}
+			if result < 0 {
+				result = i
+				continue
+			}
+			b := &registers[result]
+			if score(r) > score(b) || (score(r) == score(b) && better(r, b)) {
+				result = i
+			}
+		}
+		return result
+	}
+	active := 0
+	for i := range registers {
+		if registers[i].notes > 0 {
+			active++
+		}
+	}
+	if active < 2 {
+		return nil, nil
+	}
+	m := best(func(r *trackRegister) int { return r.highest }, func(a, b *trackRegister) bool { return a.mean() > b.mean() }, -1)
+	b := best(func(r *trackRegister) int { return r.lowest }, func(a, b *trackRegister) bool { return a.mean() < b.mean() }, m)
+	if registers[m].highest > 0 {
+		melody = []int{m}
+	}
+	if registers[b].lowest > 0 {
+		bass = []int{b}
+	}
+	return melody, bass
+}
+
+// anyTrackMatches returns whether the given partial-match regular expression matches any track name.
+func anyTrackMatches(names []string, re string) (bool, error) {
+	if re == "" {
+		return false, nil
+	}
+	r, err := regexp.Compile(re)
+	if err != nil {
+		return false, fmt.Errorf("failed to compile track name RE %v: %w", re, err)
+	}
+	for _, name := range names {
+		if r.MatchString(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TrackAssignment are the melody and bass tracks picked by register.
+type TrackAssignment struct {
+	// Nil for a role that was not assigned.
+	MelodyTracks, BassTracks []int
+}
+
+// assignTracks picks the melody and bass tracks from the register of the tracks, where neither the options nor the track name REs select any.
+func assignTracks(mid *smf.SMF, config *Config, options *Options, percussion map[routeKey]string) (TrackAssignment, error) {
+	var assigned TrackAssignment
+	names := trackNames(mid)
+	melodyMatches, err := anyTrackMatches(names, config.MelodyTrackNameRE)
+	if err != nil {
+		return assigned, err
+	}
+	bassMatches, err := anyTrackMatches(names, config.BassTrackNameRE)
+	if err != nil {
+		return assigned, err
+	}
+	needMelody := options.MelodyTracks == nil && !melodyMatches
+	needBass := options.BassTracks == nil && !bassMatches
+	if !needMelody && !needBass {
+		return assigned, nil
+	}
+
+	registers, err := analyzeRegisters(mid, percussion)
+	if err != nil {
+		return assigned, err
+	}
+	for i, r := range registers {
+		if r.notes == 0 {
+			log.Printf("Track %d (%s) plays no pitched notes.", i, names[i])
+			continue
+		}
+		log.Printf("Track %d (%s) plays %d notes from %d to %d, mean %.1f; highest voice at %d and lowest voice at %d note starts.",
+			i, names[i], r.notes, r.min, r.max, r.mean(), r.highest, r.lowest)
+	}
+	melody, bass := assignTracksByRegister(registers)
+	if needMelody {
+		if melody == nil {
+			log.Printf("Could not assign a melody track by register.")
+		} else {
+			log.Printf("Assigning melody tracks %v by register.", melody)
+			assigned.MelodyTracks = melody
+		}
+	}
+	if needBass {
+		if bass == nil {
+			log.Printf("Could not assign a bass track by register.")
+		} else {
+			log.Printf("Assigning bass tracks %v by register.", bass)
+			assigned.BassTracks = bass
+		}
+	}
+	return assigned, nil
+}
@@ -19,6 +19,8 @@ type bar struct {
 	// Helper values.
 	OrigBeatNum int
 	OrigDenom   int
+	// Bar number as printed in the hymnal.
+	Number int
 }
 
 func gcd(a, b int64) int64 {
@@ -78,6 +80,19 @@ func (b bar) FromTick(tick int64) float64 {
 
 type bars []bar
 
+// Index returns the index of the first bar with the given printed number. The number after the last bar refers to the end.
+func (b bars) Index(number int) (int, error) {
+	for i, bar := range b {
+		if bar.Number == number {
+			return i, nil
+		}
+	}
+	if len(b) > 0 && number == b[len(b)-1].Number+1 {
+		return len(b), nil
+	}
+	return 0, fmt.Errorf("bar %d not found", number)
+}
+
 func (b bars) ToTick(bar, beat, beatNum, beatDenom int) int64 {
 	if bar == len(b) && beat == 0 && beatNum == 0 {
 		return b[len(b)-1].End()
@@ -95,7 +110,33 @@ func (b bars) FromTick(tick int64) (int, float64) {
 	return 0, -1
 }
 
-func findBars(midi *smf.SMF) (bars, error) {
+// Format returns the position of the tick in bar.beat or bar.beat+num/denom form, using printed bar numbers.
+func (b bars) Format(tick int64) string {
+	if len(b) == 0 {
+		return fmt.Sprintf("tick:%d", tick)
+	}
+	if last := b[len(b)-1]; tick >= last.End() {
+		return fmt.Sprintf("%d.1", last.Number+1)
+	}
+	i, _ := b.FromTick(tick)
+	beatLen := b[i].BeatLength()
+	offset := tick - b[i].Begin
+	p := Pos{
+		Bar:       b[i].Number,
+		Beat:      int(offset/beatLen) + 1,
+		BeatNum:   int(offset % beatLen),
+		BeatDenom: int(beatLen),
+	}
+	if p.BeatNum > 0 {
+		g := gcd(int64(p.BeatNum), int64(p.BeatDenom))
+		p.BeatNum /= int(g)
+		p.BeatDenom /= int(g)
+	}
+	return p.String()
+}
+
+// findBars lays out the bars of the song according to its time signatures, starting with a pickup bar of the given length if nonzero.
+func findBars(midi *smf.SMF, pickup int64) (bars, error) {
 	type timeSig struct {
 		start               int64
 		barLen              int64
@@ -180,6 +221,12 @@ func findBars(midi *smf.SMF) (bars, error) {
 			OrigBeatNum: sig.beatNum,
 			OrigDenom:   sig.denom,
 		}
+		if time == 0 && pickup > 0 {
+			if pickup >= newBar.Length {
+				return nil, fmt.Errorf("pickup of %d ticks is not shorter than a bar of %d ticks", pickup, newBar.Length)
+			}
+			newBar.SetToLength(pickup)
+		}
 		nextSig := sigs[sigsPos+1]
 		if time+newBar.Length >= nextSig.start {
 			// Output a partial bar.
@@ -200,5 +247,43 @@ func findBars(midi *smf.SMF) (bars, error) {
 	beatLen := lastBar.BeatLength()
 	lastBeats := (lastBar.Length + beatLen - 1) / beatLen
 	lastBar.SetToLength(lastBeats * beatLen)
+	// A pickup bar is bar 0.
+	first := 1
+	if pickup > 0 {
+		first = 0
+	}
+	for i := range b {
+		b[i].Number = first + i
+	}
 	return b, nil
 }
+
+// BarNumber maps a bar of the MIDI file to its number in the printed hymnal. The following bars are numbered consecutively.
+type BarNumber struct {
+	// Printed is the bar number in the hymnal.
+	Printed int `yaml:"printed"`
+
+	// MIDI is the bar of the MIDI file, counting from 1 for the first bar, which may be a pickup bar.
+	MIDI int `yaml:"midi"`
+}
+
+// renumber shifts the printed bar numbers by the given offset, then applies the given mapping from MIDI bars.
+func (b bars) renumber(offset int, mapping []BarNumber) error {
+	for i, m := range mapping {
+		if m.MIDI < 1 || m.MIDI > len(b) {
+			return fmt.Errorf("bar number mapping to MIDI bar %d out of range", m.MIDI)
+		}
+		if i > 0 && m.MIDI <= mapping[i-1].MIDI {
+			return fmt.Errorf("bar number mapping not sorted by MIDI bar at MIDI bar %d", m.MIDI)
+		}
+	}
+	for i := range b {
+		b[i].Number += offset
+		for _, m := range mapping {
+			if m.MIDI <= i+1 {
+				b[i].Number = m.Printed + i + 1 - m.MIDI
+			}
+		}
+	}
+	return nil
+}
@@ -8,11 +8,15 @@ import (
 )
 
 // removeUnneededEvents removes events we do not care about.
-func removeUnneededEvents(mid *smf.SMF) error {
+func removeUnneededEvents(mid *smf.SMF, policy *EventPolicy) error {
+	err := policy.Validate()
+	if err != nil {
+		return err
+	}
 	tracks := make([]smf.Track, len(mid.Tracks))
 	trackTime := make([]int64, len(mid.Tracks))
-	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
-		if msg.IsOneOf(midi.ControlChangeMsg, midi.ProgramChangeMsg) {
+	err = ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		if !policy.Keeps(msg) {
 			return nil
 		}
 		tracks[track] = append(tracks[track], smf.Event{
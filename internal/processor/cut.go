@@ -2,6 +2,7 @@ package processor
 
 import (
 	"fmt"
+	"log"
 
 	"gitlab.com/gomidi/midi/v2"
 	"gitlab.com/gomidi/midi/v2/smf"
@@ -19,8 +20,49 @@ type cut struct {
 	AllNotesOffAtEnd     bool
 }
 
+type chaseKey struct {
+	track      int
+	status     uint8
+	controller uint8
+}
+
+type chasedEvent struct {
+	track int
+	msg   smf.Message
+}
+
+// isParameterControl returns whether a controller selects or sets an RPN or NRPN.
+//
+// These only make sense as a sequence, so they are never collapsed to their last value.
+func isParameterControl(controller uint8) bool {
+	switch controller {
+	case 6, 38, 96, 97, 98, 99, 100, 101:
+		return true
+	}
+	return false
+}
+
+// Ways to handle notes crossing section boundaries.
+const (
+	// CrossingNotesFail fails if a note crosses a section boundary.
+	CrossingNotesFail = ""
+	// CrossingNotesReattack ends crossing notes at the end of a section and plays them again at the start of the next.
+	CrossingNotesReattack = "reattack"
+	// CrossingNotesTruncate ends crossing notes at the end of a section and does not play their rest.
+	CrossingNotesTruncate = "truncate"
+)
+
+func validateCrossingNotes(crossing string) error {
+	switch crossing {
+	case CrossingNotesFail, CrossingNotesReattack, CrossingNotesTruncate:
+		return nil
+	default:
+		return fmt.Errorf("unknown crossing note mode %q: want %v or %v", crossing, CrossingNotesReattack, CrossingNotesTruncate)
+	}
+}
+
 // cutMIDI generates a new MIDI file from the input and a set of ranges.
-func cutMIDI(mid *smf.SMF, cuts []cut) (*smf.SMF, error) {
+func cutMIDI(tl *timeline, cuts []cut, crossing string) (*smf.SMF, error) {
 	var tracks []smf.Track
 	var trackTimes []int64
 	addEvent := func(t int, tick int64, msg smf.Message) {
@@ -44,51 +86,157 @@ func cutMIDI(mid *smf.SMF, cuts []cut) (*smf.SMF, error) {
 	}
 	tracker := NewNoteTracker(false)
 	forEachInSection := func(from, to int64, dirtyFrom, dirtyTo bool, yield func(time int64, track int, msg smf.Message) error) error {
-		tracker = NewNoteTracker(false) // TODO: make it fully local.
+		start := tl.seek(from)
+		tracker = tl.notesBefore(start) // TODO: make it fully local.
 		first := true
 		wasPlayingAtEnd := false
-		err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
-			wasPlaying := tracker.Playing()
+		// Notes actually played within the section.
+		section := NewNoteTracker(false)
+		// Notes not played again after the section start, whose note off has to be dropped.
+		truncated := map[Key]bool{}
+		startDone := false
+		splitAtStart := func() error {
+			startDone = true
+			if dirtyFrom || crossing == CrossingNotesFail {
+				return nil
+			}
+			// Notes started before the section and still playing after its first tick cross the boundary.
+			for _, k := range tracker.NotesPlaying() {
+				if tracker.NoteStart(k) >= from {
+					continue
+				}
+				switch crossing {
+				case CrossingNotesReattack:
+					log.Printf("Splitting note %v at start of section %d..%d: playing it again.", k, from, to)
+					msg := smf.Message(midi.NoteOn(k.ch, k.note, tracker.NoteVelocity(k)))
+					track := tracker.NoteTrack(k)
+					section.Handle(from, track, msg)
+					err := yield(from, track, msg)
+					if err != nil {
+						return err
+					}
+				case CrossingNotesTruncate:
+					log.Printf("Splitting note %v at start of section %d..%d: dropping its rest.", k, from, to)
+					truncated[k] = true
+				}
+			}
+			return nil
+		}
+		// All events of a tick are acted on together, so their order across tracks does not matter.
+		err := tl.forEachTick(start, func(time int64, events []timedEvent) error {
+			if time > from && !startDone {
+				err := splitAtStart()
+				if err != nil {
+					return err
+				}
+			}
 			if time > to {
 				return StopIteration
 			}
-			tracker.Handle(time, track, msg)
-			if time < from {
-				return nil
+			tracker.handleNoteEnds(time, events)
+			wasPlaying := tracker.Playing()
+			if time < to {
+				// Notes starting at the end of the section are not part of it.
+				tracker.handleNoteStarts(time, events)
 			}
-			isNoteOn := msg.GetNoteStart(nil, nil, nil)
-			isNoteOff := msg.GetNoteEnd(nil, nil)
-			ignore := (time == from && isNoteOff) || (time == to && !isNoteOff)
-			if ignore {
+			var kept []timedEvent
+			for _, ev := range events {
+				isNoteOff := ev.msg.GetNoteEnd(nil, nil)
+				ignore := (time == from && isNoteOff) || (time == to && !isNoteOff)
+				if ignore {
+					continue
+				}
+				var ch, note uint8
+				if ev.msg.GetNoteEnd(&ch, &note) && truncated[Key{ch, note}] {
+					delete(truncated, Key{ch, note})
+					continue
+				}
+				if first && ev.msg.GetNoteStart(nil, nil, nil) {
+					if !dirtyFrom && wasPlaying && crossing == CrossingNotesFail {
+						return fmt.Errorf("already playing a note at start of section %d..%d to be copied at time %d track %d", from, to, time, ev.track)
+					}
+					first = false
+				}
+				kept = append(kept, ev)
+			}
+			if len(kept) == 0 {
 				return nil
 			}
-			// TODO: Can improve algorithm of this to collect data first then act at end of every tick.
-			// Then we can even handle ticks with alternating note off and note on events.
-			if first && isNoteOn {
-				if !dirtyFrom && wasPlaying {
-					return fmt.Errorf("already playing a note at start of section %d..%d to be copied at time %d track %d", from, to, time, track)
+			section.handleNoteEnds(time, kept)
+			section.handleNoteStarts(time, kept)
+			wasPlayingAtEnd = tracker.Playing()
+			for _, ev := range kept {
+				err := yield(time, ev.track, ev.msg)
+				if err != nil {
+					return err
 				}
-				first = false
 			}
-			wasPlayingAtEnd = tracker.Playing()
-			return yield(time, track, msg)
+			return nil
 		})
 		if err != nil {
 			return err
 		}
+		if !startDone {
+			err := splitAtStart()
+			if err != nil {
+				return err
+			}
+		}
+		if !dirtyTo && crossing != CrossingNotesFail {
+			for _, k := range section.NotesPlaying() {
+				log.Printf("Splitting note %v at end of section %d..%d.", k, from, to)
+				msg := smf.Message(midi.NoteOff(k.ch, k.note))
+				track := section.NoteTrack(k)
+				tracker.Handle(to, track, msg)
+				err := yield(to, track, msg)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
 		if !dirtyTo && wasPlayingAtEnd {
 			return fmt.Errorf("still playing a note at end of section to be copied at time %d", to)
 		}
 		return nil
 	}
 	copyMeta := func(from, to int64, dirtyFrom, dirtyTo bool, outTick int64) error {
-		return forEachInSection(from, to, dirtyFrom, dirtyTo, func(time int64, track int, msg smf.Message) error {
-			if msg.IsOneOf(midi.NoteOnMsg, midi.NoteOffMsg, midi.ControlChangeMsg, midi.PitchBendMsg, midi.AfterTouchMsg, midi.PolyAfterTouchMsg, midi.ProgramChangeMsg) {
+		// Channel state is chased: only the last value of each controller is repeated, in order of last change.
+		// RPN and NRPN controllers are repeated in their original order instead.
+		var chased []chasedEvent
+		chasedIndex := map[chaseKey]int{}
+		err := forEachInSection(from, to, dirtyFrom, dirtyTo, func(time int64, track int, msg smf.Message) error {
+			if msg.IsOneOf(midi.NoteOnMsg, midi.NoteOffMsg, midi.PolyAfterTouchMsg) {
+				return nil
+			}
+			if msg.IsOneOf(midi.ControlChangeMsg, midi.PitchBendMsg, midi.AfterTouchMsg, midi.ProgramChangeMsg) {
+				k := chaseKey{track: track, status: msg[0]}
+				if msg.Is(midi.ControlChangeMsg) {
+					if isParameterControl(msg[1]) {
+						chased = append(chased, chasedEvent{track: track, msg: msg})
+						return nil
+					}
+					k.controller = msg[1]
+				}
+				if i, found := chasedIndex[k]; found {
+					chased[i].msg = nil
+				}
+				chasedIndex[k] = len(chased)
+				chased = append(chased, chasedEvent{track: track, msg: msg})
 				return nil
 			}
 			addEvent(track, outTick, msg)
 			return nil
 		})
+		if err != nil {
+			return err
+		}
+		for _, ev := range chased {
+			if ev.msg != nil {
+				addEvent(ev.track, outTick, ev.msg)
+			}
+		}
+		return nil
 	}
 	copyAll := func(from, to int64, dirtyFrom, dirtyTo bool, outTick int64) error {
 		return forEachInSection(from, to, dirtyFrom, dirtyTo, func(time int64, track int, msg smf.Message) error {
@@ -131,7 +279,7 @@ func cutMIDI(mid *smf.SMF, cuts []cut) (*smf.SMF, error) {
 	}
 
 	newMIDI := smf.NewSMF1()
-	newMIDI.TimeFormat = mid.TimeFormat
+	newMIDI.TimeFormat = tl.timeFormat
 	for _, t := range tracks {
 		newMIDI.Add(t)
 	}
@@ -14,20 +14,25 @@ func dumpTimeSig(prefix string, mid *smf.SMF, b bars) {
 			return nil
 		}
 		bar, beat := b.FromTick(time)
-		log.Printf("%s: %d.(%v) @ %d: tempo is %f bpm.", prefix, bar+1, beat+1, time, bpm)
+		number := bar + 1
+		if bar < len(b) {
+			number = b[bar].Number
+		}
+		log.Printf("%s: %d.(%v) @ %d: tempo is %f bpm.", prefix, number, beat+1, time, bpm)
 		return nil
 	})
 	var start int
 	var startTicks int64
 	var sigBar *bar
 	gotSig := func(i int, thisBar *bar) {
-		if thisBar == nil || sigBar == nil || thisBar.Num != sigBar.Num || thisBar.Denom != sigBar.Denom {
+		// Also start a new group where the printed numbering jumps.
+		if thisBar == nil || sigBar == nil || thisBar.Num != sigBar.Num || thisBar.Denom != sigBar.Denom || thisBar.Number != b[i-1].Number+1 {
 			if i != start {
 				plural := "s"
 				if i-start == 1 {
 					plural = ""
 				}
-				log.Printf("%s: %d @ %d: %d bar%s of %d/%d (beat = %d/%d).", prefix, start+1, startTicks, i-start, plural, sigBar.Num, sigBar.Denom, sigBar.BeatNum, sigBar.Denom)
+				log.Printf("%s: %d @ %d: %d bar%s of %d/%d (beat = %d/%d).", prefix, b[start].Number, startTicks, i-start, plural, sigBar.Num, sigBar.Denom, sigBar.BeatNum, sigBar.Denom)
 			}
 			start = i
 			if thisBar != nil {
@@ -40,5 +45,9 @@ func dumpTimeSig(prefix string, mid *smf.SMF, b bars) {
 		gotSig(i, &bar)
 	}
 	gotSig(len(b), nil)
-	log.Printf("%s: %d: end.", prefix, len(b)+1)
+	end := 1
+	if len(b) > 0 {
+		end = b[len(b)-1].Number + 1
+	}
+	log.Printf("%s: %d: end.", prefix, end)
 }
@@ -1,6 +1,7 @@
 package processor
 
 import (
+	"container/heap"
 	"errors"
 
 	"gitlab.com/gomidi/midi/v2/smf"
@@ -9,37 +10,64 @@ import (
 // StopIteration can be returned to return without failure.
 var StopIteration = errors.New("ForEachEventWithTime: StopIteration")
 
+type timedEvent struct {
+	time  int64
+	track int
+	msg   smf.Message
+}
+
+// trackHead is the next event of a track.
+type trackHead struct {
+	track   int
+	time    int64
+	noteOff bool
+}
+
+// trackHeads is a heap of track heads, earliest first. At the same time, note off events come first, then lower track numbers.
+type trackHeads []trackHead
+
+func (h trackHeads) Len() int { return len(h) }
+func (h trackHeads) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.time != b.time {
+		return a.time < b.time
+	}
+	if a.noteOff != b.noteOff {
+		return a.noteOff
+	}
+	return a.track < b.track
+}
+func (h trackHeads) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *trackHeads) Push(x any)   { *h = append(*h, x.(trackHead)) }
+func (h *trackHeads) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
 // ForEachEventWithTime runs the given function for each event, with current absolute time and other info.
 func ForEachEventWithTime(mid *smf.SMF, yield func(time int64, track int, msg smf.Message) error) error {
 	// trackPos is the index of the NEXT event from each track.
 	trackPos := make([]int, len(mid.Tracks))
-	// trackTime is the time of the LAST event from each track.
-	trackTime := make([]int64, len(mid.Tracks))
-	for {
-		earliestTrack := -1
-		var earliestTime int64
-		var earliestNoteOff bool
-		for i, t := range mid.Tracks {
-			p := trackPos[i]
-			if p >= len(t) {
-				// End of track.
-				continue
-			}
-			time := trackTime[i] + int64(t[p].Delta)
-			noteOff := t[p].Message.GetNoteEnd(nil, nil)
-			if earliestTrack < 0 || time < earliestTime || (time == earliestTime && noteOff && !earliestNoteOff) {
-				earliestTime = time
-				earliestTrack = i
-				earliestNoteOff = noteOff
-			}
+	var heads trackHeads
+	for i, t := range mid.Tracks {
+		if len(t) == 0 {
+			continue
 		}
-		if earliestTrack < 0 {
-			// End of MIDI.
-			return nil
-		}
-		msg := mid.Tracks[earliestTrack][trackPos[earliestTrack]].Message
+		heads = append(heads, trackHead{
+			track:   i,
+			time:    int64(t[0].Delta),
+			noteOff: t[0].Message.GetNoteEnd(nil, nil),
+		})
+	}
+	heap.Init(&heads)
+	for len(heads) > 0 {
+		earliest := heads[0]
+		t := mid.Tracks[earliest.track]
+		msg := t[trackPos[earliest.track]].Message
 		if !msg.Is(smf.MetaEndOfTrackMsg) {
-			err := yield(earliestTime, earliestTrack, mid.Tracks[earliestTrack][trackPos[earliestTrack]].Message)
+			err := yield(earliest.time, earliest.track, msg)
 			if errors.Is(err, StopIteration) {
 				return nil
 			}
@@ -47,7 +75,20 @@ func ForEachEventWithTime(mid *smf.SMF, yield func(time int64, track int, msg sm
 				return err
 			}
 		}
-		trackPos[earliestTrack]++
-		trackTime[earliestTrack] = earliestTime
+		trackPos[earliest.track]++
+		p := trackPos[earliest.track]
+		if p >= len(t) {
+			// End of track.
+			heap.Pop(&heads)
+			continue
+		}
+		heads[0] = trackHead{
+			track:   earliest.track,
+			time:    earliest.time + int64(t[p].Delta),
+			noteOff: t[p].Message.GetNoteEnd(nil, nil),
+		}
+		heap.Fix(&heads, 0)
 	}
+	// End of MIDI.
+	return nil
 }
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"fmt"
+)
+
+// Ending is an alternate ending of the verse, played instead of the default ending in the given verses.
+type Ending struct {
+	// Verses are the verse numbers, starting at 1. Negative numbers count from the last verse, which is -1.
+	Verses []int   `yaml:"verses"`
+	Ranges []Range `yaml:"ranges"`
+}
+
+// validateEndings checks the endings of the options against the verse ranges.
+func validateEndings(options *Options, verse []Range) error {
+	if verse == nil && (options.Ending != nil || options.AlternateEndings != nil) {
+		return fmt.Errorf("endings require verse ranges")
+	}
+	for i, e := range options.AlternateEndings {
+		if len(e.Verses) == 0 {
+			return fmt.Errorf("alternate ending %d has no verses", i+1)
+		}
+		for _, v := range e.Verses {
+			if v == 0 {
+				return fmt.Errorf("alternate ending %d has invalid verse 0", i+1)
+			}
+		}
+		if len(e.Ranges) == 0 {
+			return fmt.Errorf("alternate ending %d has no ranges", i+1)
+		}
+	}
+	return nil
+}
+
+// VerseEnding returns which ending to play in the given verse, counting from 0, when playing numVerses verses.
+//
+// Returns the number of the alternate ending, starting at 1, or 0 for the default ending.
+// The first alternate ending listing the verse wins.
+func VerseEnding(options *Options, verse, numVerses int) int {
+	for i, e := range options.AlternateEndings {
+		if verseMatches(e.Verses, verse, numVerses) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// verseMatches returns whether the verse numbers include the given verse of numVerses verses, counting from 0.
+func verseMatches(verses []int, verse, numVerses int) bool {
+	for _, v := range verses {
+		if v == verse+1 || v == verse-numVerses {
+			return true
+		}
+	}
+	return false
+}
+
+// alternateEndingRanges returns the ranges of each alternate ending.
+func alternateEndingRanges(options *Options) [][]Range {
+	var ranges [][]Range
+	for _, e := range options.AlternateEndings {
+		ranges = append(ranges, e.Ranges)
+	}
+	return ranges
+}
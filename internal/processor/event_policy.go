@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"fmt"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// EventPolicy selects which non-note channel events are kept.
+type EventPolicy struct {
+	// Keep is "all" to keep all events, "none" to drop all non-note channel events, or "list" to keep only the events listed below.
+	// When unset, control changes and program changes are dropped, and all other events are kept.
+	Keep string `yaml:"keep,omitempty"`
+
+	// Events to keep in "list" mode.
+	ControlChanges []int `yaml:"control_changes,omitempty"`
+	ProgramChange  bool  `yaml:"program_change,omitempty"`
+	PitchBend      bool  `yaml:"pitch_bend,omitempty"`
+	AfterTouch     bool  `yaml:"after_touch,omitempty"`
+}
+
+// Validate checks whether the policy is well formed.
+func (p *EventPolicy) Validate() error {
+	switch p.Keep {
+	case "", "all", "none", "list":
+	default:
+		return fmt.Errorf("unknown event policy %q: want all, none or list", p.Keep)
+	}
+	for _, cc := range p.ControlChanges {
+		if cc < 0 || cc > 127 {
+			return fmt.Errorf("control change %d out of range", cc)
+		}
+	}
+	return nil
+}
+
+// Keeps returns whether the given event is to be kept.
+func (p *EventPolicy) Keeps(msg smf.Message) bool {
+	if !msg.IsOneOf(midi.ControlChangeMsg, midi.ProgramChangeMsg, midi.PitchBendMsg, midi.AfterTouchMsg, midi.PolyAfterTouchMsg) {
+		return true
+	}
+	switch p.Keep {
+	case "all":
+		return true
+	case "none":
+		return false
+	case "list":
+		var cc uint8
+		switch {
+		case msg.GetControlChange(nil, &cc, nil):
+			return slices.Contains(p.ControlChanges, int(cc))
+		case msg.Is(midi.ProgramChangeMsg):
+			return p.ProgramChange
+		case msg.Is(midi.PitchBendMsg):
+			return p.PitchBend
+		default:
+			return p.AfterTouch
+		}
+	default:
+		return !msg.IsOneOf(midi.ControlChangeMsg, midi.ProgramChangeMsg)
+	}
+}
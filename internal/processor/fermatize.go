@@ -4,6 +4,8 @@ type tickFermata struct {
 	tick   int64
 	extend int64
 	rest   int64
+	// Verses the fermata applies to, as in Fermata. Empty for all verses.
+	verses []int
 
 	// Values computed from the inputs.
 	holdTick    int64 // Last tick where all notes are held.
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// foldToRange moves notes by octaves so they are playable within the key range of their channel.
+//
+// defaultRange applies to all channels not listed in ranges.
+func foldToRange(mid *smf.SMF, ranges map[uint8]KeyRange, defaultRange KeyRange) error {
+	if len(ranges) == 0 && defaultRange.IsZero() {
+		return nil
+	}
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	folded := 0
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var ch uint8
+		if msg.IsOneOf(midi.NoteOnMsg, midi.NoteOffMsg, midi.PolyAfterTouchMsg) && msg.GetChannel(&ch) {
+			r, found := ranges[ch]
+			if !found {
+				r = defaultRange
+			}
+			if !r.IsZero() {
+				note := r.Fold(msg[1])
+				if note != msg[1] {
+					if msg.GetNoteStart(nil, nil, nil) {
+						folded++
+					}
+					msg = append(smf.Message(nil), msg...)
+					msg[1] = note
+				}
+			}
+		}
+		tracks[track] = append(tracks[track], smf.Event{
+			Delta:   uint32(time - trackTime[track]),
+			Message: msg,
+		})
+		trackTime[track] = time
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mid.Tracks = tracks
+	if folded > 0 {
+		log.Printf("Folded %d notes into key ranges.", folded)
+	}
+	return nil
+}
+
+// defaultKeyRange returns the key range of all channels channelRanges does not list.
+func defaultKeyRange(config *Config) KeyRange {
+	if config.Channel <= 0 {
+		// Not remapping, so the main range applies to all other channels.
+		return config.ChannelRange
+	}
+	return KeyRange{}
+}
+
+// channelRanges collects the key ranges of the configured channels.
+func channelRanges(config *Config) (map[uint8]KeyRange, error) {
+	ranges := map[uint8]KeyRange{}
+	add := func(ch int, r KeyRange) error {
+		if ch <= 0 || r.IsZero() {
+			return nil
+		}
+		prev, found := ranges[uint8(ch-1)]
+		if found && prev != r {
+			return fmt.Errorf("conflicting key ranges for channel %d: %v and %v", ch, prev, r)
+		}
+		ranges[uint8(ch-1)] = r
+		return nil
+	}
+	err := add(config.Channel, config.ChannelRange)
+	if err != nil {
+		return nil, err
+	}
+	err = add(config.MelodyChannel, config.MelodyRange)
+	if err != nil {
+		return nil, err
+	}
+	err = add(config.BassChannel, config.BassRange)
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
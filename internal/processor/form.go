@@ -0,0 +1,151 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// VerseSection is the name of the verse in the song form. Its ranges are the verse ranges of the options.
+const VerseSection = "verse"
+
+// Section is a named part of the song form other than the verse, such as a refrain.
+type Section struct {
+	Name   string  `yaml:"name"`
+	Ranges []Range `yaml:"ranges"`
+}
+
+var sectionName = regexp.MustCompile(`^\w+$`)
+
+// sectionKey returns the section name as used in OutputKey, which is empty for the verse.
+func sectionKey(name string) string {
+	if name == VerseSection {
+		return ""
+	}
+	return name
+}
+
+// SectionTitle returns the section name of an OutputKey for display.
+func SectionTitle(key string) string {
+	if key == "" {
+		key = VerseSection
+	}
+	return strings.ToUpper(key[:1]) + key[1:]
+}
+
+// FormVerses splits the song form of the options into verses, each starting with the verse section.
+//
+// Sections before the first verse section belong to the first verse. The sections are returned as in OutputKey.
+// Without a form, there is a single verse consisting of the verse section.
+func FormVerses(options *Options) ([][]string, error) {
+	known := map[string]bool{VerseSection: true}
+	for _, s := range options.Sections {
+		if !sectionName.MatchString(s.Name) {
+			return nil, fmt.Errorf("invalid section name %q", s.Name)
+		}
+		if known[s.Name] {
+			return nil, fmt.Errorf("duplicate section %q", s.Name)
+		}
+		if len(s.Ranges) == 0 {
+			return nil, fmt.Errorf("section %q has no ranges", s.Name)
+		}
+		known[s.Name] = true
+	}
+	if len(options.Form) == 0 {
+		return [][]string{{""}}, nil
+	}
+	var verses [][]string
+	for _, name := range options.Form {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown section %q in form", name)
+		}
+		if len(verses) == 0 || (name == VerseSection && hasVerse(verses[len(verses)-1])) {
+			verses = append(verses, nil)
+		}
+		verses[len(verses)-1] = append(verses[len(verses)-1], sectionKey(name))
+	}
+	return verses, nil
+}
+
+func hasVerse(sections []string) bool {
+	for _, s := range sections {
+		if s == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// VerseSections returns the sections to play in the given verse when playing numVerses verses.
+//
+// The last verse always takes the last verse of the form, so a closing refrain is kept when playing fewer verses.
+// Other verses beyond the form repeat the second to last verse of the form.
+func VerseSections(verses [][]string, verse, numVerses int) []string {
+	if verse == numVerses-1 || len(verses) == 1 {
+		return verses[len(verses)-1]
+	}
+	return verses[min(verse, len(verses)-2)]
+}
+
+// sectionParts cuts the ranges of a section at its fermatas.
+//
+// Returns the cuts of the whole section, and the cuts of each part played on its own.
+// Parts alternate between music up to a fermata and the fermata hold.
+func sectionParts(ranges []tickRange, fermataTick []tickFermata, restBefore int64) ([]cut, [][]cut) {
+	var parts [][]cut
+	var joined []cut
+	var thisPart []cut
+	for i, p := range ranges {
+		thisCut := cut{
+			RestBefore: 0,
+			Begin:      p.Begin,
+			End:        p.End,
+			RestAfter:  0,
+		}
+		if i == 0 {
+			thisCut.RestBefore = restBefore
+		}
+		theseCuts := fermatize(thisCut, fermataTick)
+		for j, c := range theseCuts {
+			joined = append(joined, c)
+			if j == 0 {
+				thisPart = append(thisPart, c)
+			} else if j%2 == 1 {
+				// Fermata hold.
+				parts = append(parts, thisPart, []cut{c})
+				thisPart = nil
+			} else {
+				// Fermata release. When we get here, thisPart is always nil.
+				thisPart = []cut{c}
+			}
+		}
+	}
+	if len(thisPart) > 0 {
+		parts = append(parts, thisPart)
+	}
+	return joined, parts
+}
+
+// sectionVariant identifies the parts of a section as played in some verses.
+type sectionVariant struct {
+	// Verse starting at 1, or 0 if shared by all verses.
+	verse   int
+	section string
+	ending  int
+}
+
+// key returns the OutputKey of a part of the section.
+func (v sectionVariant) key(part int) OutputKey {
+	return OutputKey{Part: part, Section: v.section, Ending: v.ending, Verse: v.verse}
+}
+
+func (v sectionVariant) String() string {
+	s := SectionTitle(v.section)
+	if v.ending != 0 {
+		s += fmt.Sprintf(" with ending %d", v.ending)
+	}
+	if v.verse != 0 {
+		s += fmt.Sprintf(" in verse %d", v.verse)
+	}
+	return s
+}
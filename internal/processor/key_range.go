@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeyRange is an inclusive range of notes, e.g. the keys of a manual or a pedalboard.
+type KeyRange struct {
+	Low  uint8
+	High uint8
+}
+
+var (
+	_ yaml.Marshaler   = KeyRange{}
+	_ yaml.Unmarshaler = &KeyRange{}
+)
+
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// noteName returns the name of a note in scientific pitch notation (middle C is C4).
+func noteName(note uint8) string {
+	return fmt.Sprintf("%s%d", noteNames[note%12], int(note)/12-1)
+}
+
+var (
+	noteNameValue = regexp.MustCompile(`^([A-Ga-g])([#b]?)(-?\d+)$`)
+	keyRangeValue = regexp.MustCompile(`^([A-Ga-g][#b]?-?\d+|\d+)-([A-Ga-g][#b]?-?\d+|\d+)$`)
+)
+
+// parseNote parses a note name like C#4 or Bb3, or a plain MIDI note number.
+func parseNote(s string) (uint8, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		result := noteNameValue.FindStringSubmatch(s)
+		if result == nil {
+			return 0, fmt.Errorf("note %q is neither a note name nor a number", s)
+		}
+		octave, err := strconv.Atoi(result[3])
+		if err != nil {
+			return 0, fmt.Errorf("note %q has invalid octave: %v", s, err)
+		}
+		n = 12 * (octave + 1)
+		for i, name := range noteNames {
+			if name == strings.ToUpper(result[1]) {
+				n += i
+			}
+		}
+		switch result[2] {
+		case "#":
+			n++
+		case "b":
+			n--
+		}
+	}
+	if n < 0 || n > 127 {
+		return 0, fmt.Errorf("note %q out of range", s)
+	}
+	return uint8(n), nil
+}
+
+func (r KeyRange) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("%s-%s", noteName(r.Low), noteName(r.High)), nil
+}
+
+func (r *KeyRange) UnmarshalYAML(value *yaml.Node) error {
+	var item *string
+	err := value.Decode(&item)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return nil
+	}
+	result := keyRangeValue.FindStringSubmatch(*item)
+	if result == nil {
+		return fmt.Errorf("key range %q not in format note-note", *item)
+	}
+	r.Low, err = parseNote(result[1])
+	if err != nil {
+		return fmt.Errorf("key range %q: %v", *item, err)
+	}
+	r.High, err = parseNote(result[2])
+	if err != nil {
+		return fmt.Errorf("key range %q: %v", *item, err)
+	}
+	if r.High < r.Low+11 {
+		return fmt.Errorf("key range %q must span at least an octave", *item)
+	}
+	return nil
+}
+
+// IsZero returns whether the range is unset.
+func (r KeyRange) IsZero() bool {
+	return r == KeyRange{}
+}
+
+// Contains returns whether the note is inside the range. An unset range contains all notes.
+func (r KeyRange) Contains(note uint8) bool {
+	return r.IsZero() || (note >= r.Low && note <= r.High)
+}
+
+// Fold moves a note by octaves until it is inside the range.
+func (r KeyRange) Fold(note uint8) uint8 {
+	for note < r.Low {
+		note += 12
+	}
+	for note > r.High {
+		note -= 12
+	}
+	return note
+}
+
+func (r KeyRange) String() string {
+	s, _ := r.MarshalYAML()
+	return s.(string)
+}
@@ -2,47 +2,15 @@ package processor
 
 import (
 	"log"
-	"regexp"
 	"strings"
 
 	"gitlab.com/gomidi/midi/v2/smf"
 )
 
-// mapToChannel maps all events of the song to the given MIDI channel.
-func mapToChannel(mid *smf.SMF, ch int, melodyRE string, melodyTracks []int, melodyCh int, bassRE string, bassTracks []int, bassCh int, soloRE string, soloTracks []int) error {
-	if ch < 0 && melodyCh < 0 && bassCh < 0 {
-		// No remapping.
-		return nil
-	}
-
-	melody, err := regexp.Compile(melodyRE)
-	if err != nil {
-		return err
-	}
-	isMelody := make(map[int]bool, len(melodyTracks))
-	for _, i := range melodyTracks {
-		isMelody[i] = true
-	}
-
-	bass, err := regexp.Compile(bassRE)
-	if err != nil {
-		return err
-	}
-	isBass := make(map[int]bool, len(bassTracks))
-	for _, i := range bassTracks {
-		isBass[i] = true
-	}
-
-	solo, err := regexp.Compile(soloRE)
-	if err != nil {
-		return err
-	}
-	isSolo := make(map[int]bool, len(soloTracks))
-	for _, i := range soloTracks {
-		isSolo[i] = true
-	}
-
-	for i, t := range mid.Tracks {
+// trackNames returns the names of all tracks.
+func trackNames(mid *smf.SMF) []string {
+	var names []string
+	for _, t := range mid.Tracks {
 		var name string
 		for _, ev := range t {
 			// Take the _last_ event for the track name.
@@ -60,80 +28,127 @@ func mapToChannel(mid *smf.SMF, ch int, melodyRE string, melodyTracks []int, mel
 				}
 			}
 		}
+		names = append(names, name)
+	}
+	return names
+}
+
+type routeKey struct {
+	track int
+	ch    uint8
+}
+
+// mapToChannel sends all channel events of the song to MIDI channels according to the given routes.
+//
+// If no routes are given, they are derived from the shorthand.
+// Percussion is dropped if percussionCh is negative, and sent to percussionCh otherwise, regardless of the routes.
+func mapToChannel(mid *smf.SMF, routes []Route, shorthand *channelShorthand, percussion map[routeKey]string, percussionCh int) error {
+	names := trackNames(mid)
+	for i, name := range names {
 		log.Printf("Track %d name: %s.", i, name)
-		if melodyTracks == nil && melodyRE != "" && melody.MatchString(name) {
-			isMelody[i] = true
-		}
-		if bassTracks == nil && bassRE != "" && bass.MatchString(name) {
-			isBass[i] = true
-		}
-		if soloTracks == nil && soloRE != "" && solo.MatchString(name) {
-			isSolo[i] = true
+	}
+	if routes == nil {
+		var err error
+		routes, err = shorthand.routes(names)
+		if err != nil {
+			return err
 		}
 	}
-
-	// Disable melody or bass coupler if no special channel is requested.
-	if melodyCh < 0 || melodyCh == ch {
-		isMelody = nil
+	if len(routes) == 0 && len(percussion) == 0 {
+		// No remapping.
+		return nil
 	}
-	if bassCh < 0 || bassCh == ch {
-		isBass = nil
+	compiled, err := compileRoutes(routes)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Melody coupler tracks: %v; bass coupler tracks: %v; solo tracks: %v", isMelody, isBass, isSolo)
-
-	numTracks := len(mid.Tracks)
-	melodyTrack := numTracks
-	if len(isMelody) > 0 {
-		numTracks++
-	}
-	bassTrack := numTracks
-	if len(isBass) > 0 {
-		numTracks++
+	// Channels to send events of each track and source channel to; nil to drop them.
+	destinations := map[routeKey][]int{}
+	route := func(track int, ch uint8) []int {
+		k := routeKey{track: track, ch: ch}
+		if dest, found := destinations[k]; found {
+			return dest
+		}
+		if _, found := percussion[k]; found {
+			var dest []int
+			if percussionCh >= 0 {
+				dest = []int{percussionCh}
+			}
+			destinations[k] = dest
+			return dest
+		}
+		dest := []int{int(ch)}
+		for _, r := range compiled {
+			if !r.matches(track, names[track], ch) {
+				continue
+			}
+			if r.Drop {
+				dest = nil
+				break
+			}
+			if len(r.Channels) > 0 {
+				dest = nil
+				for _, c := range r.Channels {
+					if c == 0 {
+						dest = append(dest, int(ch))
+					} else {
+						dest = append(dest, c-1)
+					}
+				}
+			}
+			break
+		}
+		log.Printf("Routing track %d (%s) channel %d to channels %v.", track, names[track], ch+1, oneBased(dest))
+		destinations[k] = dest
+		return dest
 	}
 
-	tracks := make([]smf.Track, numTracks)
-	trackTime := make([]int64, numTracks)
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	// Copies to further channels go into one track per channel, like the couplers of an organ.
+	couplerTrack := map[int]int{}
+	add := func(outTrack int, time int64, msg smf.Message) {
+		tracks[outTrack] = append(tracks[outTrack], smf.Event{
+			Delta:   uint32(time - trackTime[outTrack]),
+			Message: msg,
+		})
+		trackTime[outTrack] = time
+	}
 	err = ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
-		channels := map[uint8]bool{}
-		wrote := false
-		out := func(outTrack int, outCh int) {
-			wrote = true
+		var evCh uint8
+		isChannel := msg.GetChannel(&evCh)
+		isMetaChannel := msg.GetMetaChannel(&evCh)
+		if !isChannel && !isMetaChannel {
+			// Not routed.
+			add(track, time, msg)
+			return nil
+		}
+		channels := map[int]bool{}
+		for i, outCh := range route(track, evCh) {
+			if channels[outCh] {
+				// Remove coupler dupes.
+				continue
+			}
+			channels[outCh] = true
 			newMsg := append(smf.Message(nil), msg...)
-			// Remap channel if requested.
-			var evCh uint8
-			if newMsg.GetChannel(&evCh) {
-				if outCh >= 0 {
-					newMsg[0] += uint8(outCh) - evCh
-					evCh = uint8(outCh)
-				}
+			if isChannel {
+				newMsg[0] += uint8(outCh) - evCh
+			} else {
+				newMsg[3] += uint8(outCh) - evCh
 			}
-			if newMsg.GetMetaChannel(&evCh) {
-				if outCh >= 0 {
-					newMsg[3] += uint8(outCh) - evCh
-					evCh = uint8(outCh)
+			outTrack := track
+			if i > 0 {
+				t, found := couplerTrack[outCh]
+				if !found {
+					t = len(tracks)
+					tracks = append(tracks, nil)
+					trackTime = append(trackTime, 0)
+					couplerTrack[outCh] = t
 				}
+				outTrack = t
 			}
-			if channels[evCh] {
-				// Remove coupler dupes.
-				return
-			}
-			channels[evCh] = true
-			tracks[outTrack] = append(tracks[outTrack], smf.Event{
-				Delta:   uint32(time - trackTime[outTrack]),
-				Message: newMsg,
-			})
-			trackTime[outTrack] = time
-		}
-		// Couplers first, even if same channel.
-		if isMelody[track] {
-			out(melodyTrack, melodyCh)
-		}
-		if isBass[track] {
-			out(bassTrack, bassCh)
-		}
-		if !wrote || !isSolo[track] {
-			out(track, ch)
+			add(outTrack, time, newMsg)
 		}
 		return nil
 	})
@@ -143,3 +158,12 @@ func mapToChannel(mid *smf.SMF, ch int, melodyRE string, melodyTracks []int, mel
 	mid.Tracks = tracks
 	return nil
 }
+
+// oneBased converts channel numbers for logging.
+func oneBased(channels []int) []int {
+	var result []int
+	for _, ch := range channels {
+		result = append(result, ch+1)
+	}
+	return result
+}
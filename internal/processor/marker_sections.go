@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"log"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// MarkerNames are the texts of the marker events defining the sections of a hymn.
+type MarkerNames struct {
+	PreludeBegin string `yaml:"prelude_begin,omitempty"`
+	PreludeEnd   string `yaml:"prelude_end,omitempty"`
+	VerseBegin   string `yaml:"verse_begin,omitempty"`
+	VerseEnd     string `yaml:"verse_end,omitempty"`
+	Fermata      string `yaml:"fermata,omitempty"`
+}
+
+// withDefaults fills in the default names.
+func (n MarkerNames) withDefaults() MarkerNames {
+	return MarkerNames{
+		PreludeBegin: WithDefault(n.PreludeBegin, "Intro"),
+		PreludeEnd:   WithDefault(n.PreludeEnd, "Intro End"),
+		VerseBegin:   WithDefault(n.VerseBegin, "Verse"),
+		VerseEnd:     WithDefault(n.VerseEnd, "Verse End"),
+		Fermata:      WithDefault(n.Fermata, "Fermata"),
+	}
+}
+
+// markerSections derives the prelude, verse and fermata positions from marker, cue point and text events.
+//
+// Each begin marker starts a range, which ends at the next end marker, or at the end of the song if there is none.
+func markerSections(mid *smf.SMF, names MarkerNames, end int64) (prelude, verse []Range, fermatas []Fermata, err error) {
+	names = names.withDefaults()
+	tickPos := func(tick int64) Pos {
+		return Pos{Tick: tick, IsTick: true}
+	}
+	var preludeBegin, verseBegin *Pos
+	err = ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var text string
+		if !msg.GetMetaMarker(&text) && !msg.GetMetaCuepoint(&text) && !msg.GetMetaText(&text) {
+			return nil
+		}
+		pos := tickPos(time)
+		switch text {
+		case names.PreludeBegin:
+			if preludeBegin == nil {
+				preludeBegin = &pos
+			}
+		case names.PreludeEnd:
+			if preludeBegin == nil {
+				log.Printf("Ignoring %q marker at tick %d without a begin marker.", text, time)
+				return nil
+			}
+			prelude = append(prelude, Range{Begin: *preludeBegin, End: pos})
+			preludeBegin = nil
+		case names.VerseBegin:
+			if verseBegin == nil {
+				verseBegin = &pos
+			}
+		case names.VerseEnd:
+			if verseBegin == nil {
+				log.Printf("Ignoring %q marker at tick %d without a begin marker.", text, time)
+				return nil
+			}
+			verse = append(verse, Range{Begin: *verseBegin, End: pos})
+			verseBegin = nil
+		case names.Fermata:
+			fermatas = append(fermatas, Fermata{Pos: pos})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if preludeBegin != nil {
+		prelude = append(prelude, Range{Begin: *preludeBegin, End: tickPos(end)})
+	}
+	if verseBegin != nil {
+		verse = append(verse, Range{Begin: *verseBegin, End: tickPos(end)})
+	}
+	return prelude, verse, fermatas, nil
+}
+
+// sectionsFromMarkers fills in the prelude, verse and fermatas not set yet from markers, and logs them.
+func sectionsFromMarkers(mid *smf.SMF, names MarkerNames, b bars, prelude, verse *[]Range, fermatas *[]Fermata) error {
+	if len(b) == 0 {
+		return nil
+	}
+	markerPrelude, markerVerse, markerFermatas, err := markerSections(mid, names, b[len(b)-1].End())
+	if err != nil {
+		return err
+	}
+	logRanges := func(what string, ranges []Range) {
+		for _, r := range ranges {
+			log.Printf("%s from markers: %s to %s.", what, b.Format(r.Begin.Tick), b.Format(r.End.Tick))
+		}
+	}
+	if *prelude == nil {
+		*prelude = markerPrelude
+		logRanges("Prelude", markerPrelude)
+	}
+	if *verse == nil {
+		*verse = markerVerse
+		logRanges("Verse", markerVerse)
+	}
+	if *fermatas == nil {
+		*fermatas = markerFermatas
+		for _, f := range markerFermatas {
+			log.Printf("Fermata from markers: %s.", b.Format(f.Pos.Tick))
+		}
+	}
+	return nil
+}
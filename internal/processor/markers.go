@@ -0,0 +1,24 @@
+package processor
+
+import (
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// findMarkers returns the tick of each marker and cue point text. If a text occurs more than once, the first one is used.
+func findMarkers(mid *smf.SMF) (map[string]int64, error) {
+	markers := map[string]int64{}
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var text string
+		if !msg.GetMetaMarker(&text) && !msg.GetMetaCuepoint(&text) {
+			return nil
+		}
+		if _, found := markers[text]; !found {
+			markers[text] = time
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return markers, nil
+}
@@ -25,6 +25,7 @@ type activeNote struct {
 	noteOnTrack int
 	refs        int
 	start       int64
+	velocity    uint8
 }
 
 type NoteTracker struct {
@@ -39,6 +40,16 @@ func NewNoteTracker(refcounting bool) *NoteTracker {
 	}
 }
 
+// clone returns an independent copy of the tracker.
+func (t NoteTracker) clone() *NoteTracker {
+	c := NewNoteTracker(t.refcounting)
+	for k, n := range t.activeNotes {
+		copied := *n
+		c.activeNotes[k] = &copied
+	}
+	return c
+}
+
 func (t NoteTracker) Playing() bool {
 	return len(t.activeNotes) > 0
 }
@@ -69,9 +80,13 @@ func (t NoteTracker) NoteTrack(k Key) int {
 	return t.activeNotes[k].noteOnTrack
 }
 
+func (t NoteTracker) NoteVelocity(k Key) uint8 {
+	return t.activeNotes[k].velocity
+}
+
 func (t NoteTracker) Handle(time int64, track int, msg smf.Message) (bool, int) {
-	var ch, note uint8
-	if msg.GetNoteStart(&ch, &note, nil) {
+	var ch, note, velocity uint8
+	if msg.GetNoteStart(&ch, &note, &velocity) {
 		k := Key{ch, note}
 		n := t.activeNotes[k]
 		result := n == nil
@@ -80,6 +95,7 @@ func (t NoteTracker) Handle(time int64, track int, msg smf.Message) (bool, int)
 				refs:        1,
 				noteOnTrack: track,
 				start:       time,
+				velocity:    velocity,
 			}
 			t.activeNotes[k] = n
 		} else if t.refcounting {
@@ -106,3 +122,23 @@ func (t NoteTracker) Handle(time int64, track int, msg smf.Message) (bool, int)
 	}
 	return true, track
 }
+
+// handleNoteEnds handles the note off events of one tick.
+//
+// Together with handleNoteStarts, this handles a tick as if its note off events came first.
+func (t NoteTracker) handleNoteEnds(time int64, events []timedEvent) {
+	for _, ev := range events {
+		if ev.msg.GetNoteEnd(nil, nil) {
+			t.Handle(time, ev.track, ev.msg)
+		}
+	}
+}
+
+// handleNoteStarts handles all other events of one tick.
+func (t NoteTracker) handleNoteStarts(time int64, events []timedEvent) {
+	for _, ev := range events {
+		if !ev.msg.GetNoteEnd(nil, nil) {
+			t.Handle(time, ev.track, ev.msg)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// OctaveCoupler adds copies of the notes of a channel at other octaves, like the 16' and 4' couplers of an organ.
+type OctaveCoupler struct {
+	// Channel is the MIDI channel (1-16).
+	Channel int `yaml:"channel"`
+
+	// Octaves to add copies at, like -1 for a sub-octave or 1 for a super-octave coupler.
+	Octaves []int `yaml:"octaves"`
+}
+
+// coupleOctaves adds the note copies of the given octave couplers.
+//
+// Copies outside the key range of their channel are not played. The copies can overlap other notes; removeRedundantNoteEvents has to run afterwards.
+func coupleOctaves(mid *smf.SMF, couplers []OctaveCoupler, ranges map[uint8]KeyRange, defaultRange KeyRange) error {
+	if len(couplers) == 0 {
+		return nil
+	}
+	octaves := map[uint8][]int{}
+	for _, c := range couplers {
+		if c.Channel < 1 || c.Channel > 16 {
+			return fmt.Errorf("octave coupler channel %d out of range", c.Channel)
+		}
+		for _, o := range c.Octaves {
+			if o == 0 || o < -10 || o > 10 {
+				return fmt.Errorf("octave coupler octave %d for channel %d out of range", o, c.Channel)
+			}
+		}
+		octaves[uint8(c.Channel-1)] = append(octaves[uint8(c.Channel-1)], c.Octaves...)
+	}
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	add := func(time int64, track int, msg smf.Message) {
+		tracks[track] = append(tracks[track], smf.Event{
+			Delta:   uint32(time - trackTime[track]),
+			Message: msg,
+		})
+		trackTime[track] = time
+	}
+	added, outside := 0, 0
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		add(time, track, msg)
+		var ch uint8
+		if !msg.IsOneOf(midi.NoteOnMsg, midi.NoteOffMsg, midi.PolyAfterTouchMsg) || !msg.GetChannel(&ch) {
+			return nil
+		}
+		r, found := ranges[ch]
+		if !found {
+			r = defaultRange
+		}
+		for _, o := range octaves[ch] {
+			note := int(msg[1]) + 12*o
+			if note < 0 || note > 127 || !r.Contains(uint8(note)) {
+				if msg.GetNoteStart(nil, nil, nil) {
+					outside++
+				}
+				continue
+			}
+			if msg.GetNoteStart(nil, nil, nil) {
+				added++
+			}
+			copied := append(smf.Message(nil), msg...)
+			copied[1] = uint8(note)
+			add(time, track, copied)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mid.Tracks = tracks
+	log.Printf("Added %d notes using octave couplers; %d more are outside the key range.", added, outside)
+	return nil
+}
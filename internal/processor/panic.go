@@ -8,18 +8,14 @@ import (
 )
 
 // panicMIDI generates a new MIDI file that turns all notes off that the input file ever plays.
-func panicMIDI(mid *smf.SMF) (*smf.SMF, error) {
+func panicMIDI(tl *timeline) (*smf.SMF, error) {
 	notes := map[Key]struct{}{}
-	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+	for _, ev := range tl.events {
 		var ch, note uint8
-		if msg.GetNoteStart(&ch, &note, nil) {
+		if ev.msg.GetNoteStart(&ch, &note, nil) {
 			k := Key{ch, note}
 			notes[k] = struct{}{}
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
 	}
 	var track smf.Track
 	var keys []Key
@@ -32,7 +28,7 @@ func panicMIDI(mid *smf.SMF) (*smf.SMF, error) {
 	}
 	track.Close(0)
 	newMIDI := smf.New()
-	newMIDI.TimeFormat = mid.TimeFormat
+	newMIDI.TimeFormat = tl.timeFormat
 	newMIDI.Add(track)
 	return newMIDI, nil
 }
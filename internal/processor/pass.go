@@ -0,0 +1,225 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// Pass is a transformation of the whole MIDI file, run before it is cut into sections.
+type Pass interface {
+	// Name is the name the pass is referred to by in pipelines.
+	Name() string
+
+	// Run transforms the MIDI file in place and reports what it changed.
+	Run(mid *smf.SMF, ctx *PassContext) (PassReport, error)
+}
+
+// PassContext holds the settings a pass may use.
+type PassContext struct {
+	Config  *Config
+	Options *Options
+
+	// percussion are the tracks and channels playing percussion, as found by the detect_percussion pass. Nil if it has not run yet.
+	percussion map[routeKey]string
+
+	// assigned are the melody and bass tracks picked by the assign_tracks pass.
+	assigned TrackAssignment
+}
+
+// detectPercussion finds the tracks and channels playing percussion, and logs them.
+func (ctx *PassContext) detectPercussion(mid *smf.SMF) error {
+	ch := ctx.Config.PercussionChannel
+	if ch < 0 || ch > 16 {
+		return fmt.Errorf("percussion channel %d out of range", ch)
+	}
+	percussion, err := detectPercussion(mid, ctx.Config.PercussionTrackNameRE)
+	if err != nil {
+		return err
+	}
+	logPercussion(mid, percussion, ch-1)
+	ctx.percussion = percussion
+	return nil
+}
+
+// findPercussion returns the tracks and channels playing percussion, detecting them now if the detect_percussion pass has not run.
+func (ctx *PassContext) findPercussion(mid *smf.SMF) (map[routeKey]string, error) {
+	if ctx.percussion == nil {
+		log.Printf("Pass detect_percussion has not run - detecting percussion now.")
+		err := ctx.detectPercussion(mid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ctx.percussion, nil
+}
+
+// IsPercussion returns whether the given track and channel play percussion, for passes that need to leave it alone.
+// Percussion is detected now if the detect_percussion pass has not run.
+func (ctx *PassContext) IsPercussion(mid *smf.SMF, track int, ch uint8) (bool, error) {
+	percussion, err := ctx.findPercussion(mid)
+	if err != nil {
+		return false, err
+	}
+	_, found := percussion[routeKey{track: track, ch: ch}]
+	return found, nil
+}
+
+// PassReport describes what a pass changed.
+type PassReport struct {
+	// Numbers of events added and removed. A modified event counts as both.
+	Added, Removed int
+
+	// Reordered is set if events changed order within a track.
+	Reordered bool
+}
+
+// Changed returns whether the pass changed anything.
+func (r PassReport) Changed() bool {
+	return r.Added > 0 || r.Removed > 0 || r.Reordered
+}
+
+func (r PassReport) String() string {
+	switch {
+	case r.Added > 0 && r.Removed > 0:
+		return fmt.Sprintf("added %d and removed %d events", r.Added, r.Removed)
+	case r.Added > 0:
+		return fmt.Sprintf("added %d events", r.Added)
+	case r.Removed > 0:
+		return fmt.Sprintf("removed %d events", r.Removed)
+	case r.Reordered:
+		return "reordered events"
+	default:
+		return "no changes"
+	}
+}
+
+type passEvent struct {
+	track int
+	time  int64
+	msg   string
+}
+
+// passEvents returns all events of the MIDI file, track by track.
+func passEvents(mid *smf.SMF) []passEvent {
+	var events []passEvent
+	for i, t := range mid.Tracks {
+		var time int64
+		for _, ev := range t {
+			time += int64(ev.Delta)
+			if ev.Message.Is(smf.MetaEndOfTrackMsg) {
+				// Passes may or may not keep this one.
+				continue
+			}
+			events = append(events, passEvent{track: i, time: time, msg: string(ev.Message)})
+		}
+	}
+	return events
+}
+
+// comparePassEvents reports the differences between the events before a pass and the MIDI file after it.
+func comparePassEvents(before []passEvent, mid *smf.SMF) PassReport {
+	after := passEvents(mid)
+	var report PassReport
+	count := map[passEvent]int{}
+	for _, ev := range before {
+		count[ev]++
+	}
+	for _, ev := range after {
+		count[ev]--
+	}
+	for _, n := range count {
+		if n > 0 {
+			report.Removed += n
+		} else {
+			report.Added -= n
+		}
+	}
+	report.Reordered = !slices.Equal(before, after)
+	return report
+}
+
+// passes are all passes available to pipelines, by name.
+var passes = map[string]Pass{}
+
+// RegisterPass makes a pass available to pipelines.
+func RegisterPass(p Pass) {
+	passes[p.Name()] = p
+}
+
+// newPipeline returns the passes to run.
+func newPipeline(config *Config, options *Options) ([]Pass, error) {
+	names := defaultPipeline
+	if config.Pipeline != nil {
+		names = config.Pipeline
+	}
+	if options.Pipeline != nil {
+		names = options.Pipeline
+	}
+	names = slices.Clone(names)
+	for _, extra := range options.ExtraPasses {
+		var err error
+		names, err = insertExtraPass(names, extra)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range defaultPipeline {
+		if !slices.Contains(names, name) && !slices.Contains(options.DisablePasses, name) {
+			log.Printf("Pipeline leaves out built-in pass %v - consider adding it, or listing it in disable_passes.", name)
+		}
+	}
+	for _, name := range options.DisablePasses {
+		if _, found := passes[name]; !found {
+			return nil, fmt.Errorf("unknown pass %q to disable", name)
+		}
+	}
+	var result []Pass
+	for _, name := range names {
+		p, found := passes[name]
+		if !found {
+			return nil, fmt.Errorf("unknown pass %q", name)
+		}
+		if slices.Contains(options.DisablePasses, name) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// insertExtraPass adds a pass given as "name", "name before other" or "name after other" to the pipeline.
+// A pass given as just its name runs last.
+func insertExtraPass(names []string, extra string) ([]string, error) {
+	fields := strings.Fields(extra)
+	switch {
+	case len(fields) == 1:
+		return append(names, fields[0]), nil
+	case len(fields) == 3 && (fields[1] == "before" || fields[1] == "after"):
+		i := slices.Index(names, fields[2])
+		if i < 0 {
+			return nil, fmt.Errorf("extra pass %q refers to pass %q not in the pipeline", extra, fields[2])
+		}
+		if fields[1] == "after" {
+			i++
+		}
+		return slices.Insert(names, i, fields[0]), nil
+	default:
+		return nil, fmt.Errorf("extra pass %q not in format name, name before other or name after other", extra)
+	}
+}
+
+// runPipeline runs the given passes in order, and logs what each of them changed.
+func runPipeline(mid *smf.SMF, pipeline []Pass, ctx *PassContext) error {
+	for _, p := range pipeline {
+		report, err := p.Run(mid, ctx)
+		if err != nil {
+			return fmt.Errorf("pass %v failed: %w", p.Name(), err)
+		}
+		log.Printf("Pass %v: %v.", p.Name(), report)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package processor
+
+import (
+	"log"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// defaultPipeline is the order of passes when none is configured.
+var defaultPipeline = []string{
+	"split_format0",
+	"detect_percussion",
+	"apply_sustain_pedal",
+	"remove_unneeded_events",
+	"remove_redundant_notes",
+	"transpose",
+	"separate_voices",
+	"assign_tracks",
+	"map_to_channel",
+	"fold_to_range",
+	"octave_couplers",
+	"fix_overlapping_notes",
+	"sort_note_off_first",
+	"force_tempo",
+	"adjust_tempo",
+}
+
+// DefaultPipeline returns the order of passes when none is configured.
+func DefaultPipeline() []string {
+	return slices.Clone(defaultPipeline)
+}
+
+// funcPass is a built-in pass. Its report is computed by comparing the events before and after.
+type funcPass struct {
+	name string
+	run  func(mid *smf.SMF, ctx *PassContext) error
+}
+
+func (p funcPass) Name() string {
+	return p.name
+}
+
+func (p funcPass) Run(mid *smf.SMF, ctx *PassContext) (PassReport, error) {
+	before := passEvents(mid)
+	err := p.run(mid, ctx)
+	if err != nil {
+		return PassReport{}, err
+	}
+	return comparePassEvents(before, mid), nil
+}
+
+func init() {
+	builtin := []funcPass{
+		// Split format 0 files first, as everything else works on tracks.
+		{"split_format0", func(mid *smf.SMF, ctx *PassContext) error {
+			if !WithDefaultPtr(ctx.Options.SplitFormat0, ctx.Config.SplitFormat0) {
+				return nil
+			}
+			return splitFormat0(mid)
+		}},
+
+		// Detect percussion while bank and program changes are still there. map_to_channel then handles it.
+		{"detect_percussion", func(mid *smf.SMF, ctx *PassContext) error {
+			return ctx.detectPercussion(mid)
+		}},
+
+		// Apply the sustain pedal while its events are still there.
+		{"apply_sustain_pedal", func(mid *smf.SMF, ctx *PassContext) error {
+			if !WithDefaultPtr(ctx.Options.ApplySustainPedal, ctx.Config.ApplySustainPedal) {
+				return nil
+			}
+			return applySustainPedal(mid)
+		}},
+
+		// Fix bad events.
+		{"remove_unneeded_events", func(mid *smf.SMF, ctx *PassContext) error {
+			events := &ctx.Config.Events
+			if ctx.Options.Events != nil {
+				events = ctx.Options.Events
+			}
+			return removeUnneededEvents(mid, events)
+		}},
+
+		// Remove duplicate note start. This also handles notes struck again under the sustain pedal.
+		{"remove_redundant_notes", func(mid *smf.SMF, ctx *PassContext) error {
+			return removeRedundantNoteEvents(mid, false, ctx.Config.HoldRedundantNotes)
+		}},
+
+		// Transpose before remapping, so all coupler copies are shifted the same way.
+		{"transpose", func(mid *smf.SMF, ctx *PassContext) error {
+			percussion, err := ctx.findPercussion(mid)
+			if err != nil {
+				return err
+			}
+			return transpose(mid, WithDefaultPtr(ctx.Options.Transpose, ctx.Config.Transpose), percussion)
+		}},
+
+		// Split polyphonic tracks into voices, so the couplers can pick them up.
+		{"separate_voices", func(mid *smf.SMF, ctx *PassContext) error {
+			percussion, err := ctx.findPercussion(mid)
+			if err != nil {
+				return err
+			}
+			return separateVoices(mid, ctx.Config.SplitVoicesTrackNameRE, ctx.Options.SplitVoicesTracks, percussion)
+		}},
+
+		// Pick melody and bass tracks by register if the track names do not tell.
+		{"assign_tracks", func(mid *smf.SMF, ctx *PassContext) error {
+			if !WithDefaultPtr(ctx.Options.AutoAssignTracks, ctx.Config.AutoAssignTracks) {
+				return nil
+			}
+			if ctx.Config.Routes != nil || ctx.Options.Routes != nil {
+				// Melody and bass tracks are not used.
+				log.Printf("Not assigning tracks by register, as routes are set.")
+				return nil
+			}
+			percussion, err := ctx.findPercussion(mid)
+			if err != nil {
+				return err
+			}
+			ctx.assigned, err = assignTracks(mid, ctx.Config, ctx.Options, percussion)
+			return err
+		}},
+
+		// Map all to MIDI channel 2 for the organ.
+		{"map_to_channel", func(mid *smf.SMF, ctx *PassContext) error {
+			config, options := ctx.Config, ctx.Options
+			percussion, err := ctx.findPercussion(mid)
+			if err != nil {
+				return err
+			}
+			routes := config.Routes
+			if options.Routes != nil {
+				routes = options.Routes
+			}
+			melodyTracks := options.MelodyTracks
+			if melodyTracks == nil {
+				melodyTracks = ctx.assigned.MelodyTracks
+			}
+			bassTracks := options.BassTracks
+			if bassTracks == nil {
+				bassTracks = ctx.assigned.BassTracks
+			}
+			return mapToChannel(mid, routes, &channelShorthand{
+				ch:           config.Channel - 1,
+				melodyRE:     config.MelodyTrackNameRE,
+				melodyTracks: melodyTracks,
+				melodyCh:     config.MelodyChannel - 1,
+				bassRE:       config.BassTrackNameRE,
+				bassTracks:   bassTracks,
+				bassCh:       config.BassChannel - 1,
+				soloRE:       config.SoloTrackNameRE,
+				soloTracks:   options.SoloTracks,
+			}, percussion, config.PercussionChannel-1)
+		}},
+
+		// Fold notes into the key ranges of the manuals and pedalboard.
+		{"fold_to_range", func(mid *smf.SMF, ctx *PassContext) error {
+			ranges, err := channelRanges(ctx.Config)
+			if err != nil {
+				return err
+			}
+			return foldToRange(mid, ranges, defaultKeyRange(ctx.Config))
+		}},
+
+		// Add octave coupler copies after folding, so they can fall outside the key range and not sound, like on an organ.
+		{"octave_couplers", func(mid *smf.SMF, ctx *PassContext) error {
+			couplers := ctx.Config.OctaveCouplers
+			if ctx.Options.OctaveCouplers != nil {
+				couplers = ctx.Options.OctaveCouplers
+			}
+			ranges, err := channelRanges(ctx.Config)
+			if err != nil {
+				return err
+			}
+			return coupleOctaves(mid, couplers, ranges, defaultKeyRange(ctx.Config))
+		}},
+
+		// Fix overlapping notes, as mapToChannel and foldToRange can cause them.
+		{"fix_overlapping_notes", func(mid *smf.SMF, ctx *PassContext) error {
+			return removeRedundantNoteEvents(mid, true, ctx.Config.HoldRedundantNotes)
+		}},
+
+		// Sort NoteOff first.
+		//
+		// This has to take place after channel remapping, as that may remove events.
+		{"sort_note_off_first", func(mid *smf.SMF, ctx *PassContext) error {
+			if ctx.Options.KeepEventOrder {
+				return nil
+			}
+			return sortNoteOffFirst(mid)
+		}},
+
+		{"force_tempo", func(mid *smf.SMF, ctx *PassContext) error {
+			if ctx.Options.QPMOverride <= 0 {
+				return nil
+			}
+			return forceTempo(mid, ctx.Options.QPMOverride)
+		}},
+
+		{"adjust_tempo", func(mid *smf.SMF, ctx *PassContext) error {
+			f := 1.0
+			if ctx.Options.BPMFactor > 0 {
+				f *= ctx.Options.BPMFactor
+			}
+			if ctx.Config.BPMFactor > 0 {
+				f *= ctx.Config.BPMFactor
+			}
+			if f == 1.0 {
+				return nil
+			}
+			return adjustTempo(mid, f)
+		}},
+	}
+	for _, p := range builtin {
+		RegisterPass(p)
+	}
+}
@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// percussionChannel is the General MIDI percussion channel (10).
+const percussionChannel = 9
+
+// Bank select MSB values of drum kits.
+const (
+	gm2DrumBank = 120
+	xgDrumBank  = 127
+)
+
+// detectPercussion finds the tracks and channels playing percussion, and returns why they do.
+//
+// A channel plays percussion if it is the percussion channel, or if a drum kit bank is selected by a program change.
+// A track plays percussion on all channels if its name matches nameRE.
+func detectPercussion(mid *smf.SMF, nameRE string) (map[routeKey]string, error) {
+	name, err := regexp.Compile(nameRE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile percussion track name RE %v: %w", nameRE, err)
+	}
+	names := trackNames(mid)
+
+	bank := map[uint8]uint8{}
+	drumKit := map[uint8]bool{}
+	used := map[routeKey]bool{}
+	err = ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var ch, cc, value uint8
+		if msg.GetControlChange(&ch, &cc, &value) && cc == midi.BankSelectMSB {
+			bank[ch] = value
+		}
+		if msg.GetProgramChange(&ch, nil) && (bank[ch] == gm2DrumBank || bank[ch] == xgDrumBank) {
+			drumKit[ch] = true
+		}
+		if msg.GetChannel(&ch) {
+			used[routeKey{track: track, ch: ch}] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []routeKey
+	for k := range used {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b routeKey) int {
+		if a.track != b.track {
+			return a.track - b.track
+		}
+		return int(a.ch) - int(b.ch)
+	})
+	percussion := map[routeKey]string{}
+	for _, k := range keys {
+		switch {
+		case nameRE != "" && name.MatchString(names[k.track]):
+			percussion[k] = "track name"
+		case k.ch == percussionChannel:
+			percussion[k] = "percussion channel"
+		case drumKit[k.ch]:
+			percussion[k] = "drum kit bank"
+		}
+	}
+	return percussion, nil
+}
+
+// logPercussion logs the decision for each track.
+func logPercussion(mid *smf.SMF, percussion map[routeKey]string, ch int) {
+	names := trackNames(mid)
+	action := "dropping it"
+	if ch >= 0 {
+		action = fmt.Sprintf("routing it to channel %d", ch+1)
+	}
+	for i := range mid.Tracks {
+		found := false
+		for c := uint8(0); c < 16; c++ {
+			reason, isPercussion := percussion[routeKey{track: i, ch: c}]
+			if !isPercussion {
+				continue
+			}
+			log.Printf("Track %d (%s) plays percussion on channel %d (%s): %s.", i, names[i], c+1, reason, action)
+			found = true
+		}
+		if !found {
+			log.Printf("Track %d (%s) plays no percussion.", i, names[i])
+		}
+	}
+}
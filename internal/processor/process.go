@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -17,6 +19,16 @@ type Pos struct {
 	Beat      int
 	BeatNum   int
 	BeatDenom int
+
+	// FromEnd counts Bar back from the end, so bar 1 is the last bar.
+	FromEnd bool
+
+	// Marker is the text of a marker or cue point event to use instead of bar and beat.
+	Marker string
+
+	// Tick is the MIDI tick to use instead of bar and beat, if IsTick is set.
+	Tick   int64
+	IsTick bool
 }
 
 var (
@@ -24,15 +36,29 @@ var (
 	_ yaml.Unmarshaler = &Pos{}
 )
 
-func (p Pos) MarshalYAML() (interface{}, error) {
+func (p Pos) String() string {
+	switch {
+	case p.Marker != "":
+		return "marker:" + p.Marker
+	case p.IsTick:
+		return fmt.Sprintf("tick:%d", p.Tick)
+	}
+	prefix := ""
+	if p.FromEnd {
+		prefix = "end-"
+	}
 	if p.BeatNum > 0 {
-		return fmt.Sprintf("%d.%d+%d/%d", p.Bar, p.Beat, p.BeatNum, p.BeatDenom), nil
+		return fmt.Sprintf("%s%d.%d+%d/%d", prefix, p.Bar, p.Beat, p.BeatNum, p.BeatDenom)
 	}
-	return fmt.Sprintf("%d.%d", p.Bar, p.Beat), nil
+	return fmt.Sprintf("%s%d.%d", prefix, p.Bar, p.Beat)
+}
+
+func (p Pos) MarshalYAML() (interface{}, error) {
+	return p.String(), nil
 }
 
 var (
-	posFlagValue = regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:\+(\d+)/(\d+))?$`)
+	posFlagValue = regexp.MustCompile(`^(end-)?(\d+)(?:\.(\d+))?(?:\+(\d+)/(\d+))?$`)
 )
 
 func (p *Pos) UnmarshalYAML(value *yaml.Node) error {
@@ -44,33 +70,49 @@ func (p *Pos) UnmarshalYAML(value *yaml.Node) error {
 	if item == nil {
 		return nil
 	}
+	if marker, found := strings.CutPrefix(*item, "marker:"); found {
+		if marker == "" {
+			return fmt.Errorf("pos %q has an empty marker", *item)
+		}
+		*p = Pos{Marker: marker}
+		return nil
+	}
+	if tick, found := strings.CutPrefix(*item, "tick:"); found {
+		*p = Pos{IsTick: true}
+		p.Tick, err = strconv.ParseInt(tick, 10, 64)
+		if err != nil || p.Tick < 0 {
+			return fmt.Errorf("pos %q not in format tick:n", *item)
+		}
+		return nil
+	}
 	result := posFlagValue.FindStringSubmatch(*item)
 	if result == nil {
-		return fmt.Errorf("pos %q not in format n.n+n/n", *item)
+		return fmt.Errorf("pos %q not in format n.n+n/n, end-n.n+n/n, tick:n or marker:text", *item)
 	}
 	*p = Pos{
 		Beat:      1,
 		BeatNum:   0,
 		BeatDenom: 1,
+		FromEnd:   result[1] != "",
 	}
-	p.Bar, err = strconv.Atoi(result[1])
+	p.Bar, err = strconv.Atoi(result[2])
 	if err != nil {
 		return fmt.Errorf("pos %q not in format n.n+n/n-n.n+n/n", *item)
 	}
-	if result[2] != "" {
-		p.Beat, err = strconv.Atoi(result[2])
+	if result[3] != "" {
+		p.Beat, err = strconv.Atoi(result[3])
 		if err != nil {
 			return fmt.Errorf("pos %q not in format n.n+n/n-n.n+n/n", *item)
 		}
 	}
-	if result[3] != "" {
-		p.BeatNum, err = strconv.Atoi(result[3])
+	if result[4] != "" {
+		p.BeatNum, err = strconv.Atoi(result[4])
 		if err != nil {
 			return fmt.Errorf("pos %q not in format n.n+n/n-n.n+n/n", *item)
 		}
 	}
-	if result[4] != "" {
-		p.BeatDenom, err = strconv.Atoi(result[4])
+	if result[5] != "" {
+		p.BeatDenom, err = strconv.Atoi(result[5])
 		if err != nil {
 			return fmt.Errorf("pos %q not in format n.n+n/n-n.n+n/n", *item)
 		}
@@ -78,8 +120,50 @@ func (p *Pos) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
-func (p Pos) ToTick(b bars) int64 {
-	return b.ToTick(p.Bar-1, p.Beat-1, p.BeatNum, p.BeatDenom)
+// ToTick resolves the position using the bars and markers of the song.
+func (p Pos) ToTick(b bars, markers map[string]int64) (int64, error) {
+	tick, err := p.toTick(b, markers)
+	if err != nil {
+		return 0, fmt.Errorf("invalid position %q: %w", p, err)
+	}
+	return tick, nil
+}
+
+func (p Pos) toTick(b bars, markers map[string]int64) (int64, error) {
+	if len(b) == 0 {
+		return 0, fmt.Errorf("song has no bars")
+	}
+	end := b[len(b)-1].End()
+	switch {
+	case p.Marker != "":
+		tick, found := markers[p.Marker]
+		if !found {
+			return 0, fmt.Errorf("marker not found")
+		}
+		return tick, nil
+	case p.IsTick:
+		if p.Tick > end {
+			return 0, fmt.Errorf("tick is after the end at %d", end)
+		}
+		return p.Tick, nil
+	}
+	var i int
+	if p.FromEnd {
+		i = len(b) - p.Bar
+		if i < 0 {
+			return 0, fmt.Errorf("song has only %d bars", len(b))
+		}
+	} else {
+		var err error
+		i, err = b.Index(p.Bar)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if i == len(b) && (p.Beat != 1 || p.BeatNum != 0) {
+		return 0, fmt.Errorf("position is after the end")
+	}
+	return b.ToTick(i, p.Beat-1, p.BeatNum, p.BeatDenom), nil
 }
 
 type Range struct {
@@ -87,8 +171,16 @@ type Range struct {
 	End   Pos `yaml:"end"`
 }
 
-func (r Range) ToTick(b bars) (int64, int64) {
-	return r.Begin.ToTick(b), r.End.ToTick(b)
+func (r Range) ToTick(b bars, markers map[string]int64) (int64, int64, error) {
+	begin, err := r.Begin.ToTick(b, markers)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := r.End.ToTick(b, markers)
+	if err != nil {
+		return 0, 0, err
+	}
+	return begin, end, nil
 }
 
 func beatsOrNotesToTicks(b bar, n int) int64 {
@@ -108,12 +200,49 @@ type Config struct {
 	BassTrackNameRE   string `yaml:"bass_track_name_re,omitempty"`
 	SoloTrackNameRE   string `yaml:"solo_track_name_re,omitempty"`
 
+	// Split format 0 files into one track per channel. Not needed in UI.
+	SplitFormat0 bool `yaml:"split_format0,omitempty"`
+
+	// Split tracks matching this into top voice, inner voices and bottom voice tracks. Not needed in UI.
+	SplitVoicesTrackNameRE string `yaml:"split_voices_track_name_re,omitempty"`
+
+	// Pick melody and bass tracks by register when no track name matches. Not needed in UI.
+	AutoAssignTracks bool `yaml:"auto_assign_tracks,omitempty"`
+
 	// Organ specific configuration or override. Should be offered as UI element.
 	Channel            int  `yaml:"channel,omitempty"`
 	MelodyChannel      int  `yaml:"melody_channel,omitempty"`
 	BassChannel        int  `yaml:"bass_channel,omitempty"`
 	HoldRedundantNotes bool `yaml:"hold_redundant_notes,omitempty"`
 
+	// Routing of tracks to channels, replacing the track name REs and channels above. Not needed in UI.
+	Routes []Route `yaml:"routes,omitempty"`
+
+	// Percussion handling: tracks to treat as percussion, and the channel to send percussion to (0 drops it). Not needed in UI.
+	PercussionTrackNameRE string `yaml:"percussion_track_name_re,omitempty"`
+	PercussionChannel     int    `yaml:"percussion_channel,omitempty"`
+
+	// Gap to insert between repeated notes, either in beats or in milliseconds. Not needed in UI.
+	ArticulationBeats float64 `yaml:"articulation_beats,omitempty"`
+	ArticulationMS    float64 `yaml:"articulation_ms,omitempty"`
+
+	// Convert the sustain pedal into note durations. Not needed in UI.
+	ApplySustainPedal bool `yaml:"apply_sustain_pedal,omitempty"`
+
+	// Which controller and program change events to keep. Not needed in UI.
+	Events EventPolicy `yaml:"events,omitempty"`
+
+	// Sound of each channel, sent at the start of every output. Not needed in UI.
+	Registration []ChannelRegistration `yaml:"registration,omitempty"`
+
+	// Key ranges of the organ. Notes outside are folded by octaves. Not needed in UI.
+	ChannelRange KeyRange `yaml:"channel_range,omitempty"`
+	MelodyRange  KeyRange `yaml:"melody_range,omitempty"`
+	BassRange    KeyRange `yaml:"bass_range,omitempty"`
+
+	// Octave couplers of the organ. Not needed in UI.
+	OctaveCouplers []OctaveCoupler `yaml:"octave_couplers,omitempty"`
+
 	// Organist preferences. Should be offered as UI element.
 	BPMFactor             float64 `yaml:"bpm_factor,omitempty"`
 	PreludePlayerRepeat   int     `yaml:"prelude_player_repeat,omitempty"`
@@ -125,15 +254,25 @@ type Config struct {
 	FermataExtendBeats int  `yaml:"fermata_extend_beats,omitempty"`
 	FermataRestBeats   int  `yaml:"fermata_rest_beats,omitempty"`
 
-	// Also future options:
-	// - Transpose
+	// Use the inferred time signature for files without one. Not needed in UI.
+	InferTimeSignature bool `yaml:"infer_time_signature,omitempty"`
+
+	// Names of the markers defining the sections with use_markers. Not needed in UI.
+	MarkerNames MarkerNames `yaml:"marker_names,omitempty"`
+
+	// Transposition in semitones. Not needed in UI.
+	Transpose int `yaml:"transpose,omitempty"`
+
+	// Names of the passes to run on the whole file, in order. Not needed in UI.
+	Pipeline []string `yaml:"pipeline,omitempty"`
 
 	// Misc for exporting. Not needed in UI.
 	RestBetweenVersesBeats int     `yaml:"rest_between_verses_beats,omitempty"`
 	WholeExportSleepSec    float64 `yaml:"whole_export_sleep_sec,omitempty"`
 
 	// Device configuration. Used by the player.
-	OutputPort string `yaml:"output_port,omitempty"`
+	OutputPort string      `yaml:"output_port,omitempty"`
+	SysEx      []SysExInit `yaml:"sysex,omitempty"`
 
 	// Subdirectory to read the data, if needed. Typically matches a locale string.
 	HymnsSubdir string `yaml:"hymns_subdir,omitempty"`
@@ -149,21 +288,56 @@ type Options struct {
 	InputFileSHA256 string `yaml:"input_file_sha256,omitempty"`
 
 	// For this module.
-	Fermatas           []Pos   `yaml:"fermatas,omitempty"`
-	Prelude            []Range `yaml:"prelude,omitempty"`
-	Verse              []Range `yaml:"verse,omitempty"`
-	Postlude           []Range `yaml:"postlude,omitempty"`
-	NumVerses          int     `yaml:"num_verses,omitempty"`
-	UnrolledNumVerses  int     `yaml:"unrolled_num_verses,omitempty"`
-	QPMOverride        float64 `yaml:"qpm_override,omitempty"`
-	BPMFactor          float64 `yaml:"bpm_factor,omitempty"`
-	MaxAdjust          int64   `yaml:"max_adjust,omitempty"`
-	KeepEventOrder     bool    `yaml:"keep_event_order,omitempty"`
-	MelodyTracks       []int   `yaml:"melody_tracks,omitempty"`
-	BassTracks         []int   `yaml:"bass_tracks,omitempty"`
-	SoloTracks         []int   `yaml:"solo_tracks,omitempty"`
-	FermatasInPrelude  *bool   `yaml:"fermatas_in_prelude,omitempty"`
-	FermatasInPostlude *bool   `yaml:"fermatas_in_postlude,omitempty"`
+	Fermatas           []Fermata `yaml:"fermatas,omitempty"`
+	Prelude            []Range   `yaml:"prelude,omitempty"`
+	Verse              []Range   `yaml:"verse,omitempty"`
+	Postlude           []Range   `yaml:"postlude,omitempty"`
+	NumVerses          int       `yaml:"num_verses,omitempty"`
+	UnrolledNumVerses  int       `yaml:"unrolled_num_verses,omitempty"`
+	QPMOverride        float64   `yaml:"qpm_override,omitempty"`
+	BPMFactor          float64   `yaml:"bpm_factor,omitempty"`
+	MaxAdjust          int64     `yaml:"max_adjust,omitempty"`
+	KeepEventOrder     bool      `yaml:"keep_event_order,omitempty"`
+	MelodyTracks       []int     `yaml:"melody_tracks,omitempty"`
+	BassTracks         []int     `yaml:"bass_tracks,omitempty"`
+	SoloTracks         []int     `yaml:"solo_tracks,omitempty"`
+	SplitVoicesTracks  []int     `yaml:"split_voices_tracks,omitempty"`
+	FermatasInPrelude  *bool     `yaml:"fermatas_in_prelude,omitempty"`
+	FermatasInPostlude *bool     `yaml:"fermatas_in_postlude,omitempty"`
+	CrossingNotes      string    `yaml:"crossing_notes,omitempty"`
+	TimeSignature      string    `yaml:"time_signature,omitempty"`
+	UseMarkers         bool      `yaml:"use_markers,omitempty"`
+
+	// Overrides of global settings.
+	Transpose         *int                  `yaml:"transpose,omitempty"`
+	ApplySustainPedal *bool                 `yaml:"apply_sustain_pedal,omitempty"`
+	ArticulationBeats *float64              `yaml:"articulation_beats,omitempty"`
+	ArticulationMS    *float64              `yaml:"articulation_ms,omitempty"`
+	Events            *EventPolicy          `yaml:"events,omitempty"`
+	Registration      []ChannelRegistration `yaml:"registration,omitempty"`
+	Pipeline          []string              `yaml:"pipeline,omitempty"`
+	Routes            []Route               `yaml:"routes,omitempty"`
+	OctaveCouplers    []OctaveCoupler       `yaml:"octave_couplers,omitempty"`
+	AutoAssignTracks  *bool                 `yaml:"auto_assign_tracks,omitempty"`
+	SplitFormat0      *bool                 `yaml:"split_format0,omitempty"`
+	MarkerNames       *MarkerNames          `yaml:"marker_names,omitempty"`
+
+	// Changes to the pipeline.
+	DisablePasses []string `yaml:"disable_passes,omitempty"`
+	ExtraPasses   []string `yaml:"extra_passes,omitempty"`
+
+	// Endings of the verse, selected by verse number.
+	Ending           []Range  `yaml:"ending,omitempty"`
+	AlternateEndings []Ending `yaml:"alternate_endings,omitempty"`
+
+	// Song form: sections other than the verse, and the order to play them in.
+	Sections []Section `yaml:"sections,omitempty"`
+	Form     []string  `yaml:"form,omitempty"`
+
+	// Bar numbering of the printed hymnal, used by all positions.
+	PickupBeats     int         `yaml:"pickup_beats,omitempty"`
+	BarNumberOffset int         `yaml:"bar_number_offset,omitempty"`
+	BarNumbers      []BarNumber `yaml:"bar_numbers,omitempty"`
 
 	// Tags for automatic selection for prelude.
 	Tags []string `yaml:"tags,omitempty"`
@@ -210,13 +384,29 @@ type OutputKey struct {
 	Special SpecialPart
 	// Part indicates the part index in case Special is Single.
 	Part int
+	// Section indicates the section of the song form in case Special is Single. Empty for the verse.
+	Section string
+	// Ending indicates the alternate ending of the verse in case Special is Single, starting at 1. Zero for the default ending.
+	Ending int
+	// Verse indicates the verse in case Special is Single and PerVerseParts, starting at 1. Zero for parts shared by all verses.
+	Verse int
 }
 
 // String converts OutputKey to a string like in a filename.
 func (k OutputKey) String() string {
 	switch k.Special {
 	case Single:
-		return fmt.Sprintf("part%d", k.Part)
+		prefix := ""
+		if k.Verse != 0 {
+			prefix = fmt.Sprintf("verse%d_", k.Verse)
+		}
+		if k.Section != "" {
+			return fmt.Sprintf("%s%s_part%d", prefix, k.Section, k.Part)
+		}
+		if k.Ending != 0 {
+			return fmt.Sprintf("%sending%d_part%d", prefix, k.Ending, k.Part)
+		}
+		return fmt.Sprintf("%spart%d", prefix, k.Part)
 	case Whole:
 		return "whole"
 	case Prelude:
@@ -233,116 +423,150 @@ func (k OutputKey) String() string {
 }
 
 // Process processes the given MIDI file and writes the result to out.
-func Process(mid *smf.SMF, config *Config, options *Options) (map[OutputKey]*smf.SMF, error) {
-	bars, err := findBars(mid)
+// Also returns the melody and bass tracks assigned by register, which the main program may write back to the options.
+func Process(mid *smf.SMF, config *Config, options *Options) (map[OutputKey]*smf.SMF, *TrackAssignment, error) {
+	err := toMetricTicks(mid)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	dumpTimeSig("Before", mid, bars)
 
-	// Fix bad events.
-	err = removeUnneededEvents(mid)
+	pickup, err := applyTimeSig(mid, options.TimeSignature, config.InferTimeSignature)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Remove duplicate note start.
-	err = removeRedundantNoteEvents(mid, false, config.HoldRedundantNotes)
+	bars, err := findBars(mid, 0)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	// Map all to MIDI channel 2 for the organ.
-	err = mapToChannel(mid, config.Channel-1, config.MelodyTrackNameRE, options.MelodyTracks, config.MelodyChannel-1, config.BassTrackNameRE, options.BassTracks, config.BassChannel-1, config.SoloTrackNameRE, options.SoloTracks)
+	if options.PickupBeats != 0 {
+		pickup = beatsOrNotesToTicks(bars[0], options.PickupBeats)
+	}
+	if pickup != 0 {
+		bars, err = findBars(mid, pickup)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err = bars.renumber(options.BarNumberOffset, options.BarNumbers)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	// Fix overlapping notes, as mapToChannel can cause them.
-	err = removeRedundantNoteEvents(mid, true, config.HoldRedundantNotes)
+	markers, err := findMarkers(mid)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	// Sort NoteOff first.
-	//
-	// This has to take place after channel remapping, as that may remove events.
-	if !options.KeepEventOrder {
-		err := sortNoteOffFirst(mid)
+	verses, err := FormVerses(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	fermatas, prelude, verse := options.Fermatas, options.Prelude, options.Verse
+	if options.UseMarkers {
+		err = sectionsFromMarkers(mid, WithDefaultPtr(options.MarkerNames, config.MarkerNames), bars, &prelude, &verse, &fermatas)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
+	err = validateEndings(options, verse)
+	if err != nil {
+		return nil, nil, err
+	}
+	dumpTimeSig("Before", mid, bars)
 
-	if options.QPMOverride > 0 {
-		err = forceTempo(mid, options.QPMOverride)
-		if err != nil {
-			return nil, err
-		}
+	registration := config.Registration
+	if options.Registration != nil {
+		registration = options.Registration
+	}
+	registrationMsgs, err := registrationEvents(registration)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	f := 1.0
-	if options.BPMFactor > 0 {
-		f *= options.BPMFactor
+	// Transform the whole file.
+	pipeline, err := newPipeline(config, options)
+	if err != nil {
+		return nil, nil, err
 	}
-	if config.BPMFactor > 0 {
-		f *= config.BPMFactor
+	ctx := &PassContext{
+		Config:  config,
+		Options: options,
 	}
-	if f != 1.0 {
-		err = adjustTempo(mid, f)
-		if err != nil {
-			return nil, err
-		}
+	err = runPipeline(mid, pipeline, ctx)
+	if err != nil {
+		return nil, nil, err
 	}
+	assigned := ctx.assigned
 
 	ticksBetweenVerses := beatsOrNotesToTicks(bars[len(bars)-1], WithDefault(config.RestBetweenVersesBeats, 1))
 	totalTicks := bars[len(bars)-1].End()
 
+	// From here on, events are only read, so index them once.
+	tl, err := newTimeline(mid)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Convert all values to ticks.
 	var fermataTick []tickFermata
-	for _, f := range options.Fermatas {
+	for _, f := range fermatas {
+		tick, err := f.Pos.ToTick(bars, markers)
+		if err != nil {
+			return nil, nil, err
+		}
+		i, _ := bars.FromTick(tick)
 		tf := tickFermata{
-			tick:   f.ToTick(bars),
-			extend: beatsOrNotesToTicks(bars[f.Bar-1], WithDefault(config.FermataExtendBeats, 1)),
-			rest:   beatsOrNotesToTicks(bars[f.Bar-1], WithDefault(config.FermataRestBeats, 1)),
+			verses: f.Verses,
+			tick:   tick,
+			extend: beatsOrNotesToTicks(bars[i], WithDefault(config.FermataExtendBeats, 1)),
+			rest:   beatsOrNotesToTicks(bars[i], WithDefault(config.FermataRestBeats, 1)),
 		}
-		err := adjustFermata(mid, &tf)
+		err = adjustFermata(tl, &tf)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		fermataTick = append(fermataTick, tf)
 	}
-	var preludeTick []tickRange
-	for _, p := range options.Prelude {
-		begin, end := p.ToTick(bars)
-		begin, err := adjustToNoNotes(mid, begin, WithDefault(options.MaxAdjust, 64))
-		if err != nil {
-			return nil, err
-		}
-		end, err = adjustToNoNotes(mid, end, WithDefault(options.MaxAdjust, 64))
-		if err != nil {
-			return nil, err
-		}
-		preludeTick = append(preludeTick, tickRange{
-			Begin: begin,
-			End:   end,
-		})
+	err = validateCrossingNotes(options.CrossingNotes)
+	if err != nil {
+		return nil, nil, err
 	}
-	var verseTick []tickRange
-	for _, p := range options.Verse {
-		begin, end := p.ToTick(bars)
-		begin, err := adjustToNoNotes(mid, begin, WithDefault(options.MaxAdjust, 64))
-		if err != nil {
-			return nil, err
+	adjust := func(tick int64) (int64, error) {
+		adjusted, err := adjustToNoNotes(tl, tick, WithDefault(options.MaxAdjust, 64))
+		if err != nil && options.CrossingNotes != CrossingNotesFail {
+			log.Printf("Not adjusting %v, will split notes there instead: %v.", tick, err)
+			return tick, nil
 		}
-		end, err = adjustToNoNotes(mid, end, WithDefault(options.MaxAdjust, 64))
-		if err != nil {
-			return nil, err
+		return adjusted, err
+	}
+	rangesToTick := func(ranges []Range) ([]tickRange, error) {
+		var ticks []tickRange
+		for _, p := range ranges {
+			begin, end, err := p.ToTick(bars, markers)
+			if err != nil {
+				return nil, err
+			}
+			begin, err = adjust(begin)
+			if err != nil {
+				return nil, err
+			}
+			end, err = adjust(end)
+			if err != nil {
+				return nil, err
+			}
+			ticks = append(ticks, tickRange{
+				Begin: begin,
+				End:   end,
+			})
 		}
-		verseTick = append(verseTick, tickRange{
-			Begin: begin,
-			End:   end,
-		})
+		return ticks, nil
+	}
+	preludeTick, err := rangesToTick(prelude)
+	if err != nil {
+		return nil, nil, err
+	}
+	verseTick, err := rangesToTick(verse)
+	if err != nil {
+		return nil, nil, err
 	}
 	if verseTick == nil {
 		verseTick = append(verseTick, tickRange{
@@ -350,21 +574,37 @@ func Process(mid *smf.SMF, config *Config, options *Options) (map[OutputKey]*smf
 			End:   totalTicks,
 		})
 	}
-	var postludeTick []tickRange
-	for _, p := range options.Postlude {
-		begin, end := p.ToTick(bars)
-		begin, err := adjustToNoNotes(mid, begin, WithDefault(options.MaxAdjust, 64))
+	// Each section has one variant, except for the verse, which has one per ending.
+	sectionTick := map[string][][]tickRange{}
+	for _, ending := range append([][]Range{options.Ending}, alternateEndingRanges(options)...) {
+		endingTick, err := rangesToTick(ending)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		end, err = adjustToNoNotes(mid, end, WithDefault(options.MaxAdjust, 64))
+		sectionTick[""] = append(sectionTick[""], append(slices.Clone(verseTick), endingTick...))
+	}
+	for _, s := range options.Sections {
+		ticks, err := rangesToTick(s.Ranges)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		postludeTick = append(postludeTick, tickRange{
-			Begin: begin,
-			End:   end,
-		})
+		sectionTick[s.Name] = [][]tickRange{ticks}
+	}
+	postludeTick, err := rangesToTick(options.Postlude)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Shorten repeated notes. As this only shortens notes, the positions computed above remain valid.
+	// This updates the timeline only.
+	articulationBeats := WithDefaultPtr(options.ArticulationBeats, config.ArticulationBeats)
+	articulationMS := WithDefaultPtr(options.ArticulationMS, config.ArticulationMS)
+	if articulationBeats > 0 && articulationMS > 0 {
+		return nil, nil, fmt.Errorf("articulation_beats and articulation_ms are mutually exclusive")
+	}
+	err = articulate(tl, bars, articulationBeats, articulationMS, fermataTick)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	log.Printf("Fermata data: %+v.", fermataTick)
@@ -377,41 +617,65 @@ func Process(mid *smf.SMF, config *Config, options *Options) (map[OutputKey]*smf
 			Begin:      p.Begin,
 			End:        p.End,
 			RestAfter:  0,
-		}, fermataTick, WithDefaultPtr(options.FermatasInPrelude, config.FermatasInPrelude))...)
+		}, fermatasForVerse(fermataTick, -1, 0), WithDefaultPtr(options.FermatasInPrelude, config.FermatasInPrelude))...)
 	}
 	log.Printf("Prelude cuts: %+v.", preludeCuts)
-	var verseCuts [][]cut
-	var joinedVerseCuts []cut
-	var thisVerseCut []cut
-	for i, p := range verseTick {
-		thisCut := cut{
-			RestBefore: 0,
-			Begin:      p.Begin,
-			End:        p.End,
-			RestAfter:  0,
+	numVerses := WithDefault(options.NumVerses, len(verses))
+	perVerse := PerVerseParts(options)
+	// variantOf returns the variant of the section to play in the given verse, counting from 0.
+	variantOf := func(section string, verse int) sectionVariant {
+		v := sectionVariant{section: section}
+		if section == "" {
+			v.ending = VerseEnding(options, verse, numVerses)
+		}
+		if perVerse {
+			v.verse = verse + 1
 		}
-		if i == 0 {
-			thisCut.RestBefore = ticksBetweenVerses
-		}
-		theseCuts := fermatize(thisCut, fermataTick)
-		for j, c := range theseCuts {
-			joinedVerseCuts = append(joinedVerseCuts, c)
-			if j == 0 {
-				thisVerseCut = append(thisVerseCut, c)
-			} else if j%2 == 1 {
-				// Fermata hold.
-				verseCuts = append(verseCuts, thisVerseCut, []cut{c})
-				thisVerseCut = nil
-			} else {
-				// Fermata release. When we get here, thisVerseCut is always nil.
-				thisVerseCut = []cut{c}
+		return v
+	}
+	var variants []sectionVariant
+	if perVerse {
+		for i := 0; i < numVerses; i++ {
+			for _, name := range VerseSections(verses, i, numVerses) {
+				v := variantOf(name, i)
+				if !slices.Contains(variants, v) {
+					variants = append(variants, v)
+				}
 			}
 		}
+	} else {
+		for ending := range sectionTick[""] {
+			variants = append(variants, sectionVariant{ending: ending})
+		}
+		for _, s := range options.Sections {
+			variants = append(variants, sectionVariant{section: s.Name})
+		}
 	}
-	if len(thisVerseCut) > 0 {
-		verseCuts = append(verseCuts, thisVerseCut)
+	joinedSectionCuts := map[sectionVariant][]cut{}
+	sectionCuts := map[sectionVariant][][]cut{}
+	for _, v := range variants {
+		var restBefore int64
+		if v.section == "" {
+			restBefore = ticksBetweenVerses
+		}
+		joinedSectionCuts[v], sectionCuts[v] = sectionParts(sectionTick[v.section][v.ending], fermatasForVerse(fermataTick, v.verse-1, numVerses), restBefore)
+		log.Printf("%v cuts: %+v.", v, sectionCuts[v])
+	}
+	// verseCuts joins the sections of a verse of the song form, counting from 0, with the rest between verses before the first one.
+	verseCuts := func(verse int) []cut {
+		var cuts []cut
+		for i, name := range VerseSections(verses, verse, numVerses) {
+			c := slices.Clone(joinedSectionCuts[variantOf(name, verse)])
+			if len(c) > 0 {
+				c[0].RestBefore = 0
+				if i == 0 {
+					c[0].RestBefore = ticksBetweenVerses
+				}
+			}
+			cuts = append(cuts, c...)
+		}
+		return cuts
 	}
-	log.Printf("Verse cuts: %+v.", verseCuts)
 	var postludeCuts []cut
 	for _, p := range postludeTick {
 		postludeCuts = append(postludeCuts, maybeFermatize(cut{
@@ -419,7 +683,7 @@ func Process(mid *smf.SMF, config *Config, options *Options) (map[OutputKey]*smf
 			Begin:      p.Begin,
 			End:        p.End,
 			RestAfter:  0,
-		}, fermataTick, WithDefaultPtr(options.FermatasInPostlude, config.FermatasInPostlude))...)
+		}, fermatasForVerse(fermataTick, -1, 0), WithDefaultPtr(options.FermatasInPostlude, config.FermatasInPostlude))...)
 	}
 	log.Printf("Postlude cuts: %+v.", postludeCuts)
 
@@ -427,94 +691,106 @@ func Process(mid *smf.SMF, config *Config, options *Options) (map[OutputKey]*smf
 
 	var cuts []cut
 	cuts = append(cuts, preludeCuts...)
-	for i := 0; i < WithDefault(options.NumVerses, 1); i++ {
-		cuts = append(cuts, joinedVerseCuts...)
+	for i := 0; i < numVerses; i++ {
+		cuts = append(cuts, verseCuts(i)...)
 	}
 	cuts = append(cuts, postludeCuts...)
-	wholeMIDI, err := cutMIDI(mid, cuts)
+	wholeMIDI, err := cutMIDI(tl, cuts, options.CrossingNotes)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	wholeMIDI, err = trim(wholeMIDI, time.Duration(float64(time.Second)*config.WholeExportSleepSec))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	output[OutputKey{Special: Whole}] = wholeMIDI
-	//newBars, err := findBars(wholeMIDI)
+	//newBars, err := findBars(wholeMIDI, 0)
 	//if err != nil {
-	//	return nil, err
+	//	return nil, nil, err
 	//}
 	//dumpTimeSig("Whole", wholeMIDI, newBars)
 
 	if len(preludeCuts) > 0 {
-		preludeMIDI, err := cutMIDI(mid, preludeCuts)
+		preludeMIDI, err := cutMIDI(tl, preludeCuts, options.CrossingNotes)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		preludeMIDI, err = trim(preludeMIDI, 0)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		output[OutputKey{Special: Prelude}] = preludeMIDI
-		newBars, err := findBars(preludeMIDI)
+		newBars, err := findBars(preludeMIDI, 0)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		dumpTimeSig("Prelude", preludeMIDI, newBars)
 	}
-	if len(joinedVerseCuts) > 0 {
-		verseMIDI, err := cutMIDI(mid, joinedVerseCuts)
+	if firstVerseCuts := verseCuts(0); len(firstVerseCuts) > 0 {
+		verseMIDI, err := cutMIDI(tl, firstVerseCuts, options.CrossingNotes)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		verseMIDI, err = trim(verseMIDI, 0)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		output[OutputKey{Special: Verse}] = verseMIDI
-		//newBars, err := findBars(verseMIDI)
+		//newBars, err := findBars(verseMIDI, 0)
 		//if err != nil {
-		//	return nil, err
+		//	return nil, nil, err
 		//}
 		//dumpTimeSig("Verse", verseMIDI, newBars)
 	}
-	for i, c := range verseCuts {
-		sectionMIDI, err := cutMIDI(mid, c)
-		if err != nil {
-			return nil, err
-		}
-		sectionMIDI, err = trim(sectionMIDI, 0)
-		if err != nil {
-			return nil, err
-		}
-		output[OutputKey{Part: i}] = sectionMIDI
-		newBars, err := findBars(sectionMIDI)
-		if err != nil {
-			return nil, err
+	for _, v := range variants {
+		for i, c := range sectionCuts[v] {
+			sectionMIDI, err := cutMIDI(tl, c, options.CrossingNotes)
+			if err != nil {
+				return nil, nil, err
+			}
+			sectionMIDI, err = trim(sectionMIDI, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			key := v.key(i)
+			output[key] = sectionMIDI
+			newBars, err := findBars(sectionMIDI, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			dumpTimeSig(key.String(), sectionMIDI, newBars)
 		}
-		dumpTimeSig(fmt.Sprintf("Section %d", i), sectionMIDI, newBars)
 	}
 	if len(postludeCuts) > 0 {
-		postludeMIDI, err := cutMIDI(mid, postludeCuts)
+		postludeMIDI, err := cutMIDI(tl, postludeCuts, options.CrossingNotes)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		postludeMIDI, err = trim(postludeMIDI, 0)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		output[OutputKey{Special: Postlude}] = postludeMIDI
-		newBars, err := findBars(postludeMIDI)
+		newBars, err := findBars(postludeMIDI, 0)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		dumpTimeSig("Postlude", postludeMIDI, newBars)
 	}
-	panicMIDI, err := panicMIDI(mid)
+
+	// Select the registration at the start of every playable output.
+	for _, outMIDI := range output {
+		prependEvents(outMIDI, registrationMsgs)
+	}
+
+	// Initialize the device at the start of the whole export, before selecting the registration.
+	prependEvents(output[OutputKey{Special: Whole}], wholeExportSysEx(config.SysEx))
+
+	panicMIDI, err := panicMIDI(tl)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	output[OutputKey{Special: Panic}] = panicMIDI
 
-	return output, nil
+	return output, &assigned, nil
 }
@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// ChannelRegistration defines the sound of a MIDI channel.
+type ChannelRegistration struct {
+	// Channel is the MIDI channel (1-16).
+	Channel int `yaml:"channel"`
+
+	// Values to send. Unset values are not sent.
+	BankMSB    *int `yaml:"bank_msb,omitempty"`
+	BankLSB    *int `yaml:"bank_lsb,omitempty"`
+	Program    *int `yaml:"program,omitempty"`
+	Volume     *int `yaml:"volume,omitempty"`
+	Expression *int `yaml:"expression,omitempty"`
+}
+
+// registrationEvents returns the events to select the given registration.
+func registrationEvents(reg []ChannelRegistration) ([]smf.Message, error) {
+	var msgs []smf.Message
+	for _, r := range reg {
+		if r.Channel < 1 || r.Channel > 16 {
+			return nil, fmt.Errorf("registration channel %d out of range", r.Channel)
+		}
+		ch := uint8(r.Channel - 1)
+		values := []struct {
+			name  string
+			value *int
+			msg   func(v uint8) midi.Message
+		}{
+			{"bank_msb", r.BankMSB, func(v uint8) midi.Message { return midi.ControlChange(ch, midi.BankSelectMSB, v) }},
+			{"bank_lsb", r.BankLSB, func(v uint8) midi.Message { return midi.ControlChange(ch, midi.BankSelectLSB, v) }},
+			{"program", r.Program, func(v uint8) midi.Message { return midi.ProgramChange(ch, v) }},
+			{"volume", r.Volume, func(v uint8) midi.Message { return midi.ControlChange(ch, midi.VolumeMSB, v) }},
+			{"expression", r.Expression, func(v uint8) midi.Message { return midi.ControlChange(ch, midi.ExpressionMSB, v) }},
+		}
+		for _, v := range values {
+			if v.value == nil {
+				continue
+			}
+			if *v.value < 0 || *v.value > 127 {
+				return nil, fmt.Errorf("registration %s %d for channel %d out of range", v.name, *v.value, r.Channel)
+			}
+			msgs = append(msgs, smf.Message(v.msg(uint8(*v.value))))
+		}
+	}
+	return msgs, nil
+}
+
+// prependEvents inserts the given events at the start of the first track.
+func prependEvents(mid *smf.SMF, msgs []smf.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	var track smf.Track
+	for _, msg := range msgs {
+		track = append(track, smf.Event{
+			Delta:   0,
+			Message: msg,
+		})
+	}
+	if len(mid.Tracks) == 0 {
+		track.Close(0)
+		mid.Tracks = append(mid.Tracks, track)
+		return
+	}
+	mid.Tracks[0] = append(track, mid.Tracks[0]...)
+}
@@ -0,0 +1,176 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+)
+
+// Route sends the events of matching tracks to MIDI channels.
+//
+// A route matches the channel events for which all of its conditions hold. The first matching route is used; events matching no route are left alone.
+type Route struct {
+	// TrackNameRE is a partial-match regular expression the track name must match.
+	TrackNameRE string `yaml:"track_name_re,omitempty"`
+
+	// Tracks are the track indexes (zero-based) to match.
+	Tracks []int `yaml:"tracks,omitempty"`
+
+	// SourceChannels are the MIDI channels (1-16) to match.
+	SourceChannels []int `yaml:"source_channels,omitempty"`
+
+	// Channels are the MIDI channels (1-16) to send matching events to; 0 keeps the source channel.
+	// The first channel stays in the track, copies to the other channels go to separate tracks.
+	Channels []int `yaml:"channels,omitempty"`
+
+	// Drop removes matching events.
+	Drop bool `yaml:"drop,omitempty"`
+}
+
+// Validate checks whether the route is well formed.
+func (r *Route) Validate() error {
+	_, err := regexp.Compile(r.TrackNameRE)
+	if err != nil {
+		return fmt.Errorf("failed to compile track name RE %v: %w", r.TrackNameRE, err)
+	}
+	for _, t := range r.Tracks {
+		if t < 0 {
+			return fmt.Errorf("route track %d out of range", t)
+		}
+	}
+	for _, ch := range r.SourceChannels {
+		if ch < 1 || ch > 16 {
+			return fmt.Errorf("route source channel %d out of range", ch)
+		}
+	}
+	for _, ch := range r.Channels {
+		if ch < 0 || ch > 16 {
+			return fmt.Errorf("route channel %d out of range", ch)
+		}
+	}
+	if r.Drop && len(r.Channels) > 0 {
+		return fmt.Errorf("route cannot both drop and send to channels %v", r.Channels)
+	}
+	return nil
+}
+
+// compiledRoute is a route ready for matching.
+type compiledRoute struct {
+	*Route
+	trackName *regexp.Regexp
+}
+
+func compileRoutes(routes []Route) ([]compiledRoute, error) {
+	var result []compiledRoute
+	for i := range routes {
+		r := &routes[i]
+		err := r.Validate()
+		if err != nil {
+			return nil, err
+		}
+		// Already validated above.
+		trackName := regexp.MustCompile(r.TrackNameRE)
+		result = append(result, compiledRoute{Route: r, trackName: trackName})
+	}
+	return result, nil
+}
+
+func (r compiledRoute) matches(track int, name string, ch uint8) bool {
+	if r.TrackNameRE != "" && !r.trackName.MatchString(name) {
+		return false
+	}
+	if r.Tracks != nil && !slices.Contains(r.Tracks, track) {
+		return false
+	}
+	if r.SourceChannels != nil && !slices.Contains(r.SourceChannels, int(ch)+1) {
+		return false
+	}
+	return true
+}
+
+// channelShorthand are the melody, bass and solo settings, which are a shorthand for routes.
+type channelShorthand struct {
+	ch           int
+	melodyRE     string
+	melodyTracks []int
+	melodyCh     int
+	bassRE       string
+	bassTracks   []int
+	bassCh       int
+	soloRE       string
+	soloTracks   []int
+}
+
+// routes returns the routes equivalent to the shorthand for the given track names.
+func (s *channelShorthand) routes(names []string) ([]Route, error) {
+	if s.ch < 0 && s.melodyCh < 0 && s.bassCh < 0 {
+		// No remapping.
+		return nil, nil
+	}
+
+	melody, err := regexp.Compile(s.melodyRE)
+	if err != nil {
+		return nil, err
+	}
+	bass, err := regexp.Compile(s.bassRE)
+	if err != nil {
+		return nil, err
+	}
+	solo, err := regexp.Compile(s.soloRE)
+	if err != nil {
+		return nil, err
+	}
+	matches := func(i int, tracks []int, re *regexp.Regexp, reStr string) bool {
+		if tracks != nil {
+			return slices.Contains(tracks, i)
+		}
+		return reStr != "" && re.MatchString(names[i])
+	}
+
+	isMelody := map[int]bool{}
+	isBass := map[int]bool{}
+	isSolo := map[int]bool{}
+	for i := range names {
+		if matches(i, s.melodyTracks, melody, s.melodyRE) {
+			isMelody[i] = true
+		}
+		if matches(i, s.bassTracks, bass, s.bassRE) {
+			isBass[i] = true
+		}
+		if matches(i, s.soloTracks, solo, s.soloRE) {
+			isSolo[i] = true
+		}
+	}
+
+	// Disable melody or bass coupler if no special channel is requested.
+	if s.melodyCh < 0 || s.melodyCh == s.ch {
+		isMelody = nil
+	}
+	if s.bassCh < 0 || s.bassCh == s.ch {
+		isBass = nil
+	}
+
+	log.Printf("Melody coupler tracks: %v; bass coupler tracks: %v; solo tracks: %v", isMelody, isBass, isSolo)
+
+	var routes []Route
+	for i := range names {
+		var channels []int
+		coupled := isMelody[i] || isBass[i]
+		if !coupled || !isSolo[i] {
+			// The general channel, or 0 to keep the source channel.
+			channels = append(channels, s.ch+1)
+		}
+		if isMelody[i] {
+			channels = append(channels, s.melodyCh+1)
+		}
+		if isBass[i] {
+			channels = append(channels, s.bassCh+1)
+		}
+		routes = append(routes, Route{
+			Tracks:   []int{i},
+			Channels: channels,
+		})
+	}
+	return routes, nil
+}
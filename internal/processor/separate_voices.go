@@ -0,0 +1,204 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// Voices a polyphonic track is split into, in the order of the added tracks.
+const (
+	topVoice = iota
+	innerVoices
+	bottomVoice
+	numVoices
+)
+
+var voiceNames = [numVoices]string{"top voice", "inner voices", "bottom voice"}
+
+// voiceSplitter tracks the notes of one polyphonic track.
+type voiceSplitter struct {
+	// Index of the track of the top voice. The other voices follow.
+	firstTrack int
+
+	// Voice of each playing note.
+	voice map[Key]int
+
+	// Last notes started in the top and bottom voice, or -1 if none yet.
+	lastTop, lastBottom int64
+
+	// Number of notes per voice, for logging.
+	notes [numVoices]int
+}
+
+// classify assigns voices to notes started at the same tick.
+//
+// A note is in the top voice if no other playing note is higher, in the bottom voice if no other playing note is lower, and an inner voice otherwise.
+// A note playing alone continues whichever of the top or bottom voice is closer.
+func (s *voiceSplitter) classify(started []Key) {
+	var playing []uint8
+	for k := range s.voice {
+		playing = append(playing, k.note)
+	}
+	for _, k := range started {
+		playing = append(playing, k.note)
+	}
+	high, low := slices.Max(playing), slices.Min(playing)
+	for _, k := range started {
+		var v int
+		switch {
+		case high == low:
+			v = topVoice
+			if s.lastTop >= 0 && s.lastBottom >= 0 && abs(int64(k.note)-s.lastBottom) < abs(int64(k.note)-s.lastTop) {
+				v = bottomVoice
+			}
+		case k.note == high:
+			v = topVoice
+		case k.note == low:
+			v = bottomVoice
+		default:
+			v = innerVoices
+		}
+		switch v {
+		case topVoice:
+			s.lastTop = int64(k.note)
+		case bottomVoice:
+			s.lastBottom = int64(k.note)
+		}
+		s.voice[k] = v
+		s.notes[v]++
+	}
+}
+
+// separateVoices splits the notes of the selected tracks into top voice, inner voices and bottom voice tracks.
+//
+// The new tracks are added at the end, three per split track, and named after it. Other events, and percussion, stay in the split track.
+func separateVoices(mid *smf.SMF, nameRE string, splitTracks []int, percussion map[routeKey]string) error {
+	names := trackNames(mid)
+	split := map[int]bool{}
+	if splitTracks != nil {
+		for _, t := range splitTracks {
+			if t < 0 || t >= len(mid.Tracks) {
+				return fmt.Errorf("track %d to split into voices out of range", t)
+			}
+			split[t] = true
+		}
+	} else if nameRE != "" {
+		re, err := regexp.Compile(nameRE)
+		if err != nil {
+			return fmt.Errorf("failed to compile split voices track name RE %v: %w", nameRE, err)
+		}
+		for i, name := range names {
+			if re.MatchString(name) {
+				split[i] = true
+			}
+		}
+	}
+	if len(split) == 0 {
+		// Nothing to do.
+		return nil
+	}
+
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	add := func(outTrack int, time int64, msg smf.Message) {
+		tracks[outTrack] = append(tracks[outTrack], smf.Event{
+			Delta:   uint32(time - trackTime[outTrack]),
+			Message: msg,
+		})
+		trackTime[outTrack] = time
+	}
+	splitters := map[int]*voiceSplitter{}
+	for i := range mid.Tracks {
+		if !split[i] {
+			continue
+		}
+		s := &voiceSplitter{
+			firstTrack: len(tracks),
+			voice:      map[Key]int{},
+			lastTop:    -1,
+			lastBottom: -1,
+		}
+		splitters[i] = s
+		for v := 0; v < numVoices; v++ {
+			name := names[i]
+			if name == "" {
+				name = fmt.Sprintf("Track %d", i)
+			}
+			tracks = append(tracks, smf.Track{smf.Event{
+				Message: smf.MetaTrackSequenceName(fmt.Sprintf("%s (%s)", name, voiceNames[v])),
+			}})
+			trackTime = append(trackTime, 0)
+		}
+	}
+
+	// Events are handled a tick at a time, as all notes starting at a tick have to be known to tell the voices apart.
+	var pending []timedEvent
+	flush := func() {
+		// Voices are assigned as if note ends came first.
+		outTrack := make([]int, len(pending))
+		for i, ev := range pending {
+			outTrack[i] = ev.track
+			s := splitters[ev.track]
+			var ch, note uint8
+			if s == nil || !ev.msg.GetNoteEnd(&ch, &note) {
+				continue
+			}
+			k := Key{ch, note}
+			if v, found := s.voice[k]; found {
+				outTrack[i] = s.firstTrack + v
+				delete(s.voice, k)
+			}
+		}
+		started := map[int][]Key{}
+		for _, ev := range pending {
+			var ch, note uint8
+			if splitters[ev.track] == nil || !ev.msg.GetNoteStart(&ch, &note, nil) {
+				continue
+			}
+			if _, found := percussion[routeKey{track: ev.track, ch: ch}]; found {
+				continue
+			}
+			started[ev.track] = append(started[ev.track], Key{ch, note})
+		}
+		for track, keys := range started {
+			splitters[track].classify(keys)
+		}
+		for i, ev := range pending {
+			s := splitters[ev.track]
+			var ch, note uint8
+			if s != nil && ev.msg.GetNoteStart(&ch, &note, nil) {
+				if v, found := s.voice[Key{ch, note}]; found {
+					outTrack[i] = s.firstTrack + v
+				}
+			}
+			add(outTrack[i], ev.time, ev.msg)
+		}
+		pending = pending[:0]
+	}
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		if len(pending) > 0 && pending[0].time != time {
+			flush()
+		}
+		pending = append(pending, timedEvent{time: time, track: track, msg: msg})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	flush()
+
+	for i := range mid.Tracks {
+		s := splitters[i]
+		if s == nil {
+			continue
+		}
+		log.Printf("Split track %d (%s) into tracks %d-%d: %d notes in the top voice, %d in inner voices, %d in the bottom voice.",
+			i, names[i], s.firstTrack, s.firstTrack+numVoices-1, s.notes[topVoice], s.notes[innerVoices], s.notes[bottomVoice])
+	}
+	mid.Tracks = tracks
+	return nil
+}
@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+
+	"gitlab.com/gomidi/midi/v2/gm"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// channelTrackName returns the name for the track holding the given channel, including the General MIDI instrument of its first program change if any.
+func channelTrackName(ch uint8, program int) string {
+	if program < 0 || ch == percussionChannel {
+		return fmt.Sprintf("Channel %d", ch+1)
+	}
+	return fmt.Sprintf("Channel %d (%v)", ch+1, gm.Instr(program))
+}
+
+// splitFormat0 moves the channel events of a format 0 file to one new track per channel, so the file can be handled like format 1.
+//
+// Other events stay in the first track. The new tracks are in channel order and named after their channel.
+// Files with more than one track are left alone.
+func splitFormat0(mid *smf.SMF) error {
+	if mid.Format() != 0 || len(mid.Tracks) != 1 {
+		return nil
+	}
+
+	// First pass: find the channels used, and their first programs.
+	var used [16]bool
+	var program [16]int
+	for i := range program {
+		program[i] = -1
+	}
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var ch, prog uint8
+		if msg.GetChannel(&ch) || msg.GetMetaChannel(&ch) {
+			used[ch] = true
+		}
+		if msg.GetProgramChange(&ch, &prog) && program[ch] < 0 {
+			program[ch] = int(prog)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tracks := []smf.Track{nil}
+	trackTime := []int64{0}
+	var channelTrack [16]int
+	for ch := uint8(0); ch < 16; ch++ {
+		if !used[ch] {
+			continue
+		}
+		channelTrack[ch] = len(tracks)
+		name := channelTrackName(ch, program[ch])
+		log.Printf("Splitting channel %d of format 0 file into track %d (%s).", ch+1, len(tracks), name)
+		tracks = append(tracks, smf.Track{smf.Event{
+			Message: smf.MetaTrackSequenceName(name),
+		}})
+		trackTime = append(trackTime, 0)
+	}
+
+	// Second pass: distribute the events.
+	err = ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		outTrack := 0
+		var ch uint8
+		if msg.GetChannel(&ch) || msg.GetMetaChannel(&ch) {
+			outTrack = channelTrack[ch]
+		}
+		tracks[outTrack] = append(tracks[outTrack], smf.Event{
+			Delta:   uint32(time - trackTime[outTrack]),
+			Message: msg,
+		})
+		trackTime[outTrack] = time
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mid.Tracks = tracks
+	return nil
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"log"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+type sustainedNote struct {
+	track int
+	key   Key
+}
+
+// applySustainPedal extends notes while the sustain pedal (CC64) is down, by moving their note off events to the pedal release.
+//
+// Notes struck again while sustained are left overlapping; removeRedundantNoteEvents has to run afterwards.
+func applySustainPedal(mid *smf.SMF) error {
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	add := func(time int64, track int, msg smf.Message) {
+		tracks[track] = append(tracks[track], smf.Event{
+			Delta:   uint32(time - trackTime[track]),
+			Message: msg,
+		})
+		trackTime[track] = time
+	}
+	pedalDown := map[uint8]bool{}
+	sustained := map[sustainedNote]struct{}{}
+	release := func(time int64, ch uint8, all bool) {
+		var notes []sustainedNote
+		for n := range sustained {
+			if all || n.key.ch == ch {
+				notes = append(notes, n)
+			}
+		}
+		// Keep the output deterministic.
+		sort.Slice(notes, func(i, j int) bool {
+			a, b := notes[i], notes[j]
+			if a.track != b.track {
+				return a.track < b.track
+			}
+			if a.key.ch != b.key.ch {
+				return a.key.ch < b.key.ch
+			}
+			return a.key.note < b.key.note
+		})
+		for _, n := range notes {
+			add(time, n.track, smf.Message(midi.NoteOff(n.key.ch, n.key.note)))
+			delete(sustained, n)
+		}
+	}
+	extended := 0
+	var lastTime int64
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		lastTime = time
+		var ch, cc, value, note uint8
+		if msg.GetControlChange(&ch, &cc, &value) && cc == midi.HoldPedalSwitch {
+			down := value >= 64
+			if pedalDown[ch] && !down {
+				release(time, ch, false)
+			}
+			pedalDown[ch] = down
+		}
+		if msg.GetNoteStart(&ch, &note, nil) {
+			// Struck again while sustained: the note keeps playing, and its next note off ends it.
+			for n := range sustained {
+				if n.key == (Key{ch: ch, note: note}) {
+					delete(sustained, n)
+				}
+			}
+		}
+		if msg.GetNoteEnd(&ch, &note) && pedalDown[ch] {
+			sustained[sustainedNote{track: track, key: Key{ch: ch, note: note}}] = struct{}{}
+			extended++
+			return nil
+		}
+		add(time, track, msg)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Pedal still down at the end.
+	release(lastTime, 0, true)
+	mid.Tracks = tracks
+	if extended > 0 {
+		log.Printf("Extended %d notes using the sustain pedal.", extended)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// SysExInit defines System Exclusive messages to initialize an output device with.
+type SysExInit struct {
+	// PortRE is a partial-match regular expression the output port name must match. Empty matches all ports.
+	PortRE string `yaml:"port_re,omitempty"`
+
+	// Files are .syx files to send.
+	Files []string `yaml:"files,omitempty"`
+
+	// Hex are messages given as hex strings.
+	Hex []string `yaml:"hex,omitempty"`
+
+	// InWholeExport also adds the messages to the start of the whole export.
+	InWholeExport bool `yaml:"in_whole_export,omitempty"`
+
+	// Messages are the parsed messages from Files and Hex. Filled in when loading the config.
+	Messages []smf.Message `yaml:"-"`
+}
+
+// MatchesPort returns whether the messages are to be sent to the given port.
+func (s *SysExInit) MatchesPort(port string) (bool, error) {
+	if s.PortRE == "" {
+		return true, nil
+	}
+	portRE, err := regexp.Compile(s.PortRE)
+	if err != nil {
+		return false, fmt.Errorf("failed to compile port RE %v: %w", s.PortRE, err)
+	}
+	return portRE.MatchString(port), nil
+}
+
+// ParseSysEx splits a sequence of System Exclusive messages, as found in .syx files, and validates them.
+func ParseSysEx(data []byte) ([]smf.Message, error) {
+	var msgs []smf.Message
+	start := -1
+	for i, b := range data {
+		switch {
+		case b == 0xF0:
+			if start >= 0 {
+				return nil, fmt.Errorf("unterminated SysEx message at byte %d", start)
+			}
+			start = i
+		case b == 0xF7:
+			if start < 0 {
+				return nil, fmt.Errorf("SysEx end without start at byte %d", i)
+			}
+			msgs = append(msgs, smf.Message(append([]byte(nil), data[start:i+1]...)))
+			start = -1
+		case b >= 0x80:
+			return nil, fmt.Errorf("invalid byte %02X in SysEx at byte %d", b, i)
+		case start < 0:
+			return nil, fmt.Errorf("data outside SysEx message at byte %d", i)
+		}
+	}
+	if start >= 0 {
+		return nil, fmt.Errorf("unterminated SysEx message at byte %d", start)
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("no SysEx message found")
+	}
+	return msgs, nil
+}
+
+// ParseSysExHex parses System Exclusive messages given as a hex string like "F0 7E 7F 09 01 F7".
+func ParseSysExHex(s string) ([]smf.Message, error) {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex string %q: %w", s, err)
+	}
+	return ParseSysEx(data)
+}
+
+// wholeExportSysEx returns the SysEx messages to add to the whole export.
+func wholeExportSysEx(inits []SysExInit) []smf.Message {
+	var msgs []smf.Message
+	for _, init := range inits {
+		if init.InWholeExport {
+			msgs = append(msgs, init.Messages...)
+		}
+	}
+	return msgs
+}
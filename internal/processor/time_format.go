@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// smpteResolution is the number of ticks per quarter note to convert SMPTE time to.
+const smpteResolution = 960
+
+// toMetricTicks converts a MIDI file using SMPTE time to metric ticks, as all processing works in beats.
+//
+// SMPTE time is absolute, and tempo events do not affect it. So the tempo events of the file are taken to define where the beats are.
+// With no tempo events, this is at 120 quarter notes per minute, which is the MIDI default.
+func toMetricTicks(mid *smf.SMF) error {
+	var ticksPerSecond float64
+	switch tf := mid.TimeFormat.(type) {
+	case smf.MetricTicks:
+		return nil
+	case smf.TimeCode:
+		if tf.SubFrames == 0 {
+			return fmt.Errorf("unsupported time format %v: no subframes", tf)
+		}
+		switch tf.FramesPerSecond {
+		case 24, 25, 30:
+			ticksPerSecond = float64(tf.FramesPerSecond) * float64(tf.SubFrames)
+		case 29:
+			// Drop frame.
+			ticksPerSecond = 30000.0 / 1001.0 * float64(tf.SubFrames)
+		default:
+			return fmt.Errorf("unsupported time format %v: invalid frame rate", tf)
+		}
+	default:
+		return fmt.Errorf("unsupported time format %v", mid.TimeFormat)
+	}
+
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	// Position of the last tempo change, in seconds and in metric ticks.
+	var tempoSeconds float64
+	var tempoTicks int64
+	ticksPerSecondMetric := 120.0 / 60.0 * smpteResolution
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		seconds := float64(time) / ticksPerSecond
+		newTime := tempoTicks + int64(math.Round((seconds-tempoSeconds)*ticksPerSecondMetric))
+		delta := newTime - trackTime[track]
+		if delta > math.MaxUint32 {
+			return fmt.Errorf("time %v at track %d too large after converting SMPTE time", time, track)
+		}
+		var qpm float64
+		if msg.GetMetaTempo(&qpm) {
+			if qpm <= 0 {
+				return fmt.Errorf("invalid tempo %v at time %d track %d", qpm, time, track)
+			}
+			tempoSeconds = seconds
+			tempoTicks = newTime
+			ticksPerSecondMetric = qpm / 60.0 * smpteResolution
+		}
+		tracks[track] = append(tracks[track], smf.Event{
+			Delta:   uint32(delta),
+			Message: msg,
+		})
+		trackTime[track] = newTime
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Converted time format %v to %d ticks per quarter note.", mid.TimeFormat, smpteResolution)
+	mid.Tracks = tracks
+	mid.TimeFormat = smf.MetricTicks(smpteResolution)
+	return nil
+}
@@ -0,0 +1,272 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// TimeSignatureAuto as time signature infers it from the notes.
+const TimeSignatureAuto = "auto"
+
+// timeSig is a time signature as used in MIDI files.
+type timeSig struct {
+	num, denom uint8
+
+	// Number of MIDI clocks per beat; 24 is a quarter note.
+	clocksPerBeat uint8
+}
+
+func (s timeSig) String() string {
+	return fmt.Sprintf("%d/%d", s.num, s.denom)
+}
+
+// newTimeSig returns the time signature with the usual beat: a dotted note for compound meters like 6/8, a note of the denominator otherwise.
+func newTimeSig(num, denom uint8) timeSig {
+	clocks := 96 / int(denom)
+	if denom >= 8 && num > 3 && num%3 == 0 {
+		clocks *= 3
+	}
+	return timeSig{num: num, denom: denom, clocksPerBeat: uint8(clocks)}
+}
+
+// parseTimeSig parses a time signature of the form num/denom.
+func parseTimeSig(s string) (timeSig, error) {
+	numStr, denomStr, found := strings.Cut(s, "/")
+	if !found {
+		return timeSig{}, fmt.Errorf("invalid time signature %q: want num/denom", s)
+	}
+	num, err := strconv.Atoi(numStr)
+	if err != nil || num < 1 || num > 255 {
+		return timeSig{}, fmt.Errorf("invalid time signature %q: bad numerator", s)
+	}
+	denom, err := strconv.Atoi(denomStr)
+	if err != nil || denom < 1 || denom > 32 || denom&(denom-1) != 0 {
+		return timeSig{}, fmt.Errorf("invalid time signature %q: bad denominator", s)
+	}
+	return newTimeSig(uint8(num), uint8(denom)), nil
+}
+
+// barTicks returns the length of a bar.
+func (s timeSig) barTicks(ppq int64) int64 {
+	return 4 * ppq * int64(s.num) / int64(s.denom)
+}
+
+// fileTimeSigs returns the time signatures of the MIDI file.
+func fileTimeSigs(mid *smf.SMF) ([]timeSig, error) {
+	var sigs []timeSig
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var sig timeSig
+		if msg.GetMetaTimeSig(&sig.num, &sig.denom, &sig.clocksPerBeat, nil) {
+			sigs = append(sigs, sig)
+		}
+		return nil
+	})
+	return sigs, err
+}
+
+// inferTimeSig guesses the time signature of the notes.
+//
+// Each note start on the eighth note grid is weighted by the length of the note, as notes on strong beats tend to be longer.
+// The beat is the quarter or dotted quarter grid on which note starts are strongest, and the bar is the number of beats (2, 3 or 4) whose first beat stands out most, preferring 4/4 and 6/8.
+// Also returns the tick of the first downbeat, which is nonzero for a pickup.
+func inferTimeSig(mid *smf.SMF) (timeSig, int64, bool, error) {
+	ppq := int64(mid.TimeFormat.(smf.MetricTicks))
+	if ppq%2 != 0 {
+		return timeSig{}, 0, false, nil
+	}
+	eighth := ppq / 2
+
+	type noteKey struct {
+		track int
+		ch    uint8
+		note  uint8
+	}
+	started := map[noteKey]int64{}
+	var accent []float64
+	addAccent := func(start, end int64) {
+		if start%eighth != 0 {
+			return
+		}
+		i := int(start / eighth)
+		for len(accent) <= i {
+			accent = append(accent, 0)
+		}
+		accent[i] += 1 + min(float64(end-start)/float64(ppq), 4)
+	}
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var ch, note uint8
+		if msg.GetNoteEnd(&ch, &note) {
+			k := noteKey{track, ch, note}
+			if start, found := started[k]; found {
+				addAccent(start, time)
+				delete(started, k)
+			}
+		} else if msg.GetNoteStart(&ch, &note, nil) && ch != percussionChannel {
+			started[noteKey{track, ch, note}] = time
+		}
+		return nil
+	})
+	if err != nil {
+		return timeSig{}, 0, false, err
+	}
+	const minEighths = 32
+	if len(accent) < minEighths {
+		return timeSig{}, 0, false, nil
+	}
+
+	// contrast returns how much the accents at the given positions exceed the others, considering only every stride-th eighth from offset.
+	contrast := func(period, phase, stride, offset int) float64 {
+		var in, out float64
+		var nIn, nOut int
+		for i := offset; i < len(accent); i += stride {
+			if i%period == phase {
+				in += accent[i]
+				nIn++
+			} else {
+				out += accent[i]
+				nOut++
+			}
+		}
+		if nIn == 0 || nOut == 0 {
+			return 0
+		}
+		return in/float64(nIn) - out/float64(nOut)
+	}
+
+	// Accent differences below this are noise.
+	const minContrast = 0.1
+
+	// Find the beat.
+	bestBeat, bestBeatPhase := 0, 0
+	bestBeatScore := minContrast
+	for _, beat := range []int{2, 3} {
+		for phase := 0; phase < beat; phase++ {
+			score := contrast(beat, phase, 1, 0)
+			if score > bestBeatScore {
+				bestBeat, bestBeatPhase, bestBeatScore = beat, phase, score
+			}
+		}
+	}
+	if bestBeat == 0 {
+		return timeSig{}, 0, false, nil
+	}
+
+	// Find the bar. Candidates are in order of preference, and need to be clearly better to replace a preferred one.
+	candidates := []int{4, 3, 2}
+	if bestBeat == 3 {
+		candidates = []int{2, 4, 3}
+	}
+	const margin = 1.05
+	bestBeats, bestBarPhase := 0, 0
+	bestBarScore := minContrast
+	for _, beats := range candidates {
+		bar := beats * bestBeat
+		for phase := bestBeatPhase; phase < bar; phase += bestBeat {
+			score := contrast(bar, phase, bestBeat, bestBeatPhase)
+			if score > bestBarScore*margin {
+				bestBeats, bestBarPhase, bestBarScore = beats, phase, score
+			}
+		}
+	}
+	if bestBeats == 0 {
+		return timeSig{}, 0, false, nil
+	}
+
+	var sig timeSig
+	if bestBeat == 2 {
+		sig = newTimeSig(uint8(bestBeats), 4)
+	} else {
+		sig = newTimeSig(uint8(3*bestBeats), 8)
+	}
+	return sig, int64(bestBarPhase) * eighth, true, nil
+}
+
+// setTimeSig replaces all time signatures of the MIDI file by the given one.
+func setTimeSig(mid *smf.SMF, sig timeSig) error {
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	tracks[0] = append(tracks[0], smf.Event{
+		Message: smf.MetaTimeSig(sig.num, sig.denom, sig.clocksPerBeat, 8),
+	})
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		if msg.GetMetaTimeSig(nil, nil, nil, nil) {
+			return nil
+		}
+		tracks[track] = append(tracks[track], smf.Event{
+			Delta:   uint32(time - trackTime[track]),
+			Message: msg,
+		})
+		trackTime[track] = time
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mid.Tracks = tracks
+	return nil
+}
+
+// applyTimeSig decides on the time signature of the MIDI file.
+//
+// An override in the options replaces the time signatures of the file. Otherwise, the inferred time signature is used if the file has none and inference is enabled.
+// A warning is logged if the file has a single time signature with a different bar length than inferred.
+// When the inferred time signature is used, also returns the tick of its first downbeat as the length of the pickup.
+func applyTimeSig(mid *smf.SMF, override string, infer bool) (int64, error) {
+	if len(mid.Tracks) == 0 {
+		return 0, nil
+	}
+	ppq := int64(mid.TimeFormat.(smf.MetricTicks))
+	sigs, err := fileTimeSigs(mid)
+	if err != nil {
+		return 0, err
+	}
+	if override == "" && len(sigs) > 1 {
+		// Nothing to use the inferred time signature for, or to compare it to.
+		return 0, nil
+	}
+	inferred, downbeat, ok, err := inferTimeSig(mid)
+	if err != nil {
+		return 0, err
+	}
+
+	switch override {
+	case "":
+		if len(sigs) == 1 {
+			if ok && sigs[0].barTicks(ppq) != inferred.barTicks(ppq) {
+				log.Printf("Time signature %v of the file disagrees with the inferred time signature %v - consider setting time_signature.", sigs[0], inferred)
+			}
+			return 0, nil
+		}
+		if !ok {
+			log.Printf("No time signature found, and could not infer one - assuming 4/4.")
+			return 0, nil
+		}
+		if !infer {
+			if inferred.barTicks(ppq) != newTimeSig(4, 4).barTicks(ppq) {
+				log.Printf("No time signature found - assuming 4/4, which disagrees with the inferred time signature %v - consider setting time_signature.", inferred)
+			}
+			return 0, nil
+		}
+		log.Printf("No time signature found - using inferred %v with the first downbeat at tick %d.", inferred, downbeat)
+		return downbeat, setTimeSig(mid, inferred)
+	case TimeSignatureAuto:
+		if !ok {
+			return 0, fmt.Errorf("could not infer time signature")
+		}
+		log.Printf("Using inferred time signature %v with the first downbeat at tick %d.", inferred, downbeat)
+		return downbeat, setTimeSig(mid, inferred)
+	default:
+		sig, err := parseTimeSig(override)
+		if err != nil {
+			return 0, err
+		}
+		if ok && sig.barTicks(ppq) != inferred.barTicks(ppq) {
+			log.Printf("Time signature %v disagrees with the inferred time signature %v.", sig, inferred)
+		}
+		return 0, setTimeSig(mid, sig)
+	}
+}
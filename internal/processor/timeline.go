@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"errors"
+	"sort"
+
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// snapshotInterval is the number of ticks between note state snapshots.
+const snapshotInterval = 64
+
+// timeline is an index of all events of a MIDI file by absolute time.
+//
+// It is built once, so that sections can be visited without scanning the file from the start every time.
+type timeline struct {
+	timeFormat smf.TimeFormat
+	numTracks  int
+
+	// events are all events, in the order of ForEachEventWithTime.
+	events []timedEvent
+
+	// tickStart are the indexes of the first event of each tick, followed by len(events).
+	tickStart []int
+
+	// snapshots are the notes playing before every snapshotInterval-th tick.
+	snapshots []*NoteTracker
+}
+
+func newTimeline(mid *smf.SMF) (*timeline, error) {
+	tl := &timeline{
+		timeFormat: mid.TimeFormat,
+		numTracks:  len(mid.Tracks),
+	}
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		tl.events = append(tl.events, timedEvent{time: time, track: track, msg: msg})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	tl.index()
+	return tl, nil
+}
+
+// index rebuilds the tick index and the note state snapshots. Has to be called after changing events.
+func (tl *timeline) index() {
+	tl.tickStart = nil
+	for i, ev := range tl.events {
+		if i == 0 || ev.time != tl.events[i-1].time {
+			tl.tickStart = append(tl.tickStart, i)
+		}
+	}
+	tl.tickStart = append(tl.tickStart, len(tl.events))
+
+	tl.snapshots = nil
+	tracker := NewNoteTracker(false)
+	for i := 0; i < tl.numTicks(); i++ {
+		if i%snapshotInterval == 0 {
+			tl.snapshots = append(tl.snapshots, tracker.clone())
+		}
+		time, events := tl.tick(i)
+		tracker.handleNoteEnds(time, events)
+		tracker.handleNoteStarts(time, events)
+	}
+}
+
+// numTicks returns the number of ticks that have events.
+func (tl *timeline) numTicks() int {
+	return len(tl.tickStart) - 1
+}
+
+// tick returns the time and the events of the given tick index.
+func (tl *timeline) tick(i int) (int64, []timedEvent) {
+	events := tl.events[tl.tickStart[i]:tl.tickStart[i+1]]
+	return events[0].time, events
+}
+
+// seek returns the index of the first tick at or after the given time.
+func (tl *timeline) seek(time int64) int {
+	return sort.Search(tl.numTicks(), func(i int) bool {
+		return tl.events[tl.tickStart[i]].time >= time
+	})
+}
+
+// notesBefore returns a new note tracker with the notes playing before the given tick index.
+func (tl *timeline) notesBefore(i int) *NoteTracker {
+	if len(tl.snapshots) == 0 {
+		return NewNoteTracker(false)
+	}
+	s := min(i/snapshotInterval, len(tl.snapshots)-1)
+	tracker := tl.snapshots[s].clone()
+	for j := s * snapshotInterval; j < i; j++ {
+		time, events := tl.tick(j)
+		tracker.handleNoteEnds(time, events)
+		tracker.handleNoteStarts(time, events)
+	}
+	return tracker
+}
+
+// forEachTick runs the given function for all events of each tick at once, starting at the given tick index.
+func (tl *timeline) forEachTick(i int, yield func(time int64, events []timedEvent) error) error {
+	for ; i < tl.numTicks(); i++ {
+		time, events := tl.tick(i)
+		err := yield(time, events)
+		if errors.Is(err, StopIteration) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
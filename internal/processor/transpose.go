@@ -0,0 +1,41 @@
+package processor
+
+import (
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2"
+	"gitlab.com/gomidi/midi/v2/smf"
+)
+
+// transpose shifts all notes of the song by the given number of semitones.
+//
+// Percussion is left alone, as its keys select instruments.
+func transpose(mid *smf.SMF, semitones int, percussion map[routeKey]string) error {
+	if semitones == 0 {
+		return nil
+	}
+	tracks := make([]smf.Track, len(mid.Tracks))
+	trackTime := make([]int64, len(mid.Tracks))
+	err := ForEachEventWithTime(mid, func(time int64, track int, msg smf.Message) error {
+		var ch uint8
+		if msg.IsOneOf(midi.NoteOnMsg, midi.NoteOffMsg, midi.PolyAfterTouchMsg) && msg.GetChannel(&ch) && percussion[routeKey{track: track, ch: ch}] == "" {
+			note := int(msg[1]) + semitones
+			if note < 0 || note > 127 {
+				return fmt.Errorf("note %d transposed by %d is out of range at time %d track %d", msg[1], semitones, time, track)
+			}
+			msg = append(smf.Message(nil), msg...)
+			msg[1] = uint8(note)
+		}
+		tracks[track] = append(tracks[track], smf.Event{
+			Delta:   uint32(time - trackTime[track]),
+			Message: msg,
+		})
+		trackTime[track] = time
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	mid.Tracks = tracks
+	return nil
+}
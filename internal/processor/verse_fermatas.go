@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fermata is the position of a fermata, optionally only applying to some verses.
+//
+// In YAML, a fermata applying to all verses can be given as just its position.
+type Fermata struct {
+	Pos Pos `yaml:"pos"`
+	// Verses are the verse numbers as in Ending. Empty for all verses.
+	Verses []int `yaml:"verses,omitempty"`
+}
+
+// fermataFields is Fermata without its YAML methods.
+type fermataFields Fermata
+
+func (f Fermata) MarshalYAML() (interface{}, error) {
+	if len(f.Verses) == 0 {
+		return f.Pos, nil
+	}
+	return fermataFields(f), nil
+}
+
+func (f *Fermata) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*f = Fermata{}
+		return value.Decode(&f.Pos)
+	}
+	var fields fermataFields
+	err := value.Decode(&fields)
+	if err != nil {
+		return err
+	}
+	for _, v := range fields.Verses {
+		if v == 0 {
+			return fmt.Errorf("fermata %v has invalid verse 0", fields.Pos)
+		}
+	}
+	*f = Fermata(fields)
+	return nil
+}
+
+// PerVerseParts returns whether the parts of a verse differ between verses, as some fermatas only apply to some verses.
+// The parts are then written for each verse of the hymn, and OutputKey.Verse selects the verse.
+func PerVerseParts(options *Options) bool {
+	for _, f := range options.Fermatas {
+		if len(f.Verses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PartsVerse returns which verse of the hymn to play as the given verse when playing numVerses verses, counting from 0.
+//
+// The last verse played is the last verse of the hymn, so settings for the last verse are kept when playing fewer verses.
+// Other verses beyond the hymn repeat its second to last verse.
+func PartsVerse(verse, numVerses, hymnNumVerses int) int {
+	if verse == numVerses-1 || hymnNumVerses == 1 {
+		return hymnNumVerses - 1
+	}
+	return min(verse, hymnNumVerses-2)
+}
+
+// fermatasForVerse returns the fermatas applying to the given verse of numVerses verses, counting from 0.
+// A negative verse selects the fermatas applying to all verses.
+func fermatasForVerse(fermataTick []tickFermata, verse, numVerses int) []tickFermata {
+	var result []tickFermata
+	for _, tf := range fermataTick {
+		if len(tf.verses) == 0 || (verse >= 0 && verseMatches(tf.verses, verse, numVerses)) {
+			result = append(result, tf)
+		}
+	}
+	return result
+}